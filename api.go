@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// registerAPI adds the JSON api to the router.  Unlike the html pages it
+// always speaks JSON, including for errors, so that scripts have a stable
+// contract to rely on.
+func registerAPI(router *mux.Router, repo Repository) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	api.Methods("GET").Path("/entries").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiListEntries(repo, w, req)
+	})
+
+	api.Methods("POST").Path("/entries").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiCreateEntry(repo, w, req)
+	})
+
+	api.Methods("GET").Path("/entries/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiGetEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	api.Methods("PUT").Path("/entries/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiReplaceEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	api.Methods("PATCH").Path("/entries/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiPatchEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	api.Methods("DELETE").Path("/entries/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiDeleteEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	api.Methods("POST").Path("/quick").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiQuickAdd(repo, w, req)
+	})
+
+	api.Methods("POST").Path("/import").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiImport(repo, w, req)
+	})
+
+	api.Methods("GET").Path("/search").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiSearch(repo, w, req)
+	})
+
+	api.Methods("GET").Path("/tags").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		apiListTags(repo, w, req)
+	})
+
+	api.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if allowed := allowedMethods(api, req); len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, "method %s not allowed for %s", req.Method, req.URL.Path)
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, "no such resource: %s %s", req.Method, req.URL.Path)
+	})
+}
+
+// allowedMethods returns the methods of the routes that match the path of
+// the request, so that the api can tell them apart from missing resources.
+func allowedMethods(router *mux.Router, req *http.Request) []string {
+	var allowed []string
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// routes without methods, like the catch-all one
+			return nil
+		}
+		for _, method := range methods {
+			r := req.Clone(req.Context())
+			r.Method = method
+			var match mux.RouteMatch
+			if route.Match(r, &match) {
+				allowed = append(allowed, method)
+			}
+		}
+		return nil
+	})
+	return allowed
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+
+	// Fields lists the problems with invalid entries by field.
+	Fields ValidationError `json:"fields,omitempty"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeAPIJSON(w, status, map[string]apiError{
+		"error": {Status: status, Message: fmt.Sprintf(format, args...)},
+	})
+}
+
+// writeAPIInvalid responds with the problems of an invalid entry, or
+// with a generic error if err is not a ValidationError.
+func writeAPIInvalid(w http.ResponseWriter, err error) {
+	errs, ok := err.(ValidationError)
+	if !ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid entry: %s", err)
+		return
+	}
+	writeAPIJSON(w, http.StatusUnprocessableEntity, map[string]apiError{
+		"error": {Status: http.StatusUnprocessableEntity, Message: "invalid entry: " + errs.Error(), Fields: errs},
+	})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(val)
+	if err != nil {
+		log.Printf("Could not write response: %s", err)
+	}
+}
+
+// apiListEntries lists a page of entries, see parseListQuery for the
+// supported parameters.  Links to the next and previous pages are sent
+// in the Link header.
+func apiListEntries(repo Repository, w http.ResponseWriter, req *http.Request) {
+	q, err := parseListQuery(req.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	page, err := repo.List(req.Context(), q)
+	if err != nil {
+		log.Printf("Could not list entries: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not list entries: %s", err)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = page.Entries.RenderJSON(buf)
+	if err != nil {
+		log.Printf("Could not render entries: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not render entries: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if links := linkHeader(req.URL, page); links != "" {
+		w.Header().Set("Link", links)
+	}
+	writeWithETag(w, req, "", buf)
+}
+
+// apiListTags lists all tags with the number of entries that have them,
+// the most used ones first.
+func apiListTags(repo Repository, w http.ResponseWriter, req *http.Request) {
+	tags, err := repo.Tags(req.Context())
+	if err != nil {
+		log.Printf("Could not list tags: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not list tags: %s", err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, tags)
+}
+
+// apiQuickAdd creates an entry from a line of text like `coffee 2 @08:30
+// oat milk`, see ParseQuickAdd.  With "preview" set, the parsed entry is
+// returned without saving it.
+func apiQuickAdd(repo Repository, w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Text    string `json:"text"`
+		Preview bool   `json:"preview"`
+	}
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid json: %s", err)
+		return
+	}
+
+	entry, err := ParseQuickAdd(body.Text, time.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	err = ValidateEntry(entry)
+	if err != nil {
+		writeAPIInvalid(w, err)
+		return
+	}
+
+	if body.Preview {
+		writeAPIJSON(w, http.StatusOK, entry)
+		return
+	}
+
+	id, err := repo.Create(req.Context(), entry)
+	if err != nil {
+		log.Printf("Could not create entry: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not create entry")
+		return
+	}
+
+	apiRespondStored(repo, w, req, id, http.StatusCreated)
+}
+
+// apiImport imports the CSV or JSON in the body, depending on its
+// Content-Type.  Options are given as parameters, see parseImportOptions.
+// If any row is invalid nothing is imported and 422 is returned.
+func apiImport(repo Repository, w http.ResponseWriter, req *http.Request) {
+	opts, err := parseImportOptions(req, importFormat(req.Header.Get("Content-Type"), ""))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	result, err := Import(req.Context(), repo, http.MaxBytesReader(w, req.Body, maxImportSize), opts)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "could not import: %s", err)
+		return
+	}
+
+	status := http.StatusOK
+	if len(result.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeAPIJSON(w, status, result)
+}
+
+// apiSearch searches entries, see parseSearchQuery for the supported
+// parameters.
+func apiSearch(repo Repository, w http.ResponseWriter, req *http.Request) {
+	q, err := parseSearchQuery(req.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	if q.Text == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing search query in q")
+		return
+	}
+
+	results, err := repo.Search(req.Context(), q)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSearch) {
+			writeAPIError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		log.Printf("Could not search: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not search: %s", err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, results)
+}
+
+func apiGetEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, ok := apiLookupEntry(repo, w, req, id)
+	if !ok {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err := entry.RenderJSON(buf)
+	if err != nil {
+		log.Printf("Could not render entry: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not render entry: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeWithETag(w, req, entry.ETag(), buf)
+}
+
+// apiCheckVersion responds with 412 Precondition Failed unless the client
+// wants to change the current version of the entry, as indicated by the
+// If-Match header or the version in the body.
+func apiCheckVersion(w http.ResponseWriter, req *http.Request, entry *Entry, version int64) bool {
+	if !ifMatch(req, entry.ETag()) || (version != 0 && version != entry.Version) {
+		writeAPIError(w, http.StatusPreconditionFailed, "entry %q has been changed, it is at version %d now", entry.ID, entry.Version)
+		return false
+	}
+	return true
+}
+
+// apiLookupEntry gets the entry with the given id, responding with an
+// error if it can't be found or is in the trash.
+func apiLookupEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) (*Entry, bool) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil {
+		log.Printf("Could not get entry: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not get entry: %s", err)
+		return nil, false
+	}
+
+	if entry == nil || entry.DeletedAt != nil {
+		writeAPIError(w, http.StatusNotFound, "no entry with id %q", id)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// apiDecodeEntry parses the request body into an entry, responding with an
+// error if that fails.
+func apiDecodeEntry(w http.ResponseWriter, req *http.Request) (*Entry, bool) {
+	if !isJSON(req) {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "expected Content-Type application/json")
+		return nil, false
+	}
+
+	entry, err := FromJSON(req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "could not parse entry: %s", err)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func apiCreateEntry(repo Repository, w http.ResponseWriter, req *http.Request) {
+	entry, ok := apiDecodeEntry(w, req)
+	if !ok {
+		return
+	}
+
+	if entry.Type == "" {
+		writeAPIError(w, http.StatusBadRequest, "type must not be empty")
+		return
+	}
+	if entry.Date.IsZero() {
+		entry.Date = time.Now().UTC().Round(time.Millisecond)
+	}
+
+	err := ValidateEntry(entry)
+	if err != nil {
+		writeAPIInvalid(w, err)
+		return
+	}
+
+	id, err := repo.Create(req.Context(), entry)
+	if err != nil {
+		log.Printf("Could not create entry: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not create entry: %s", err)
+		return
+	}
+
+	apiRespondStored(repo, w, req, id, http.StatusCreated)
+}
+
+// apiReplaceEntry replaces an entry, keeping the stored date and type if
+// the replacement doesn't have them.
+func apiReplaceEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, ok := apiLookupEntry(repo, w, req, id)
+	if !ok {
+		return
+	}
+
+	replacement, ok := apiDecodeEntry(w, req)
+	if !ok {
+		return
+	}
+
+	if !apiCheckVersion(w, req, entry, replacement.Version) {
+		return
+	}
+
+	replacement.ID = entry.ID
+	replacement.Version = entry.Version
+	keepDateAndType(replacement, entry)
+
+	err := ValidateEntry(replacement)
+	if err != nil {
+		writeAPIInvalid(w, err)
+		return
+	}
+
+	apiUpdate(repo, w, req, replacement)
+}
+
+// apiPatchEntry applies a JSON Merge Patch or JSON Patch to an entry.
+func apiPatchEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, status, err := applyRequestPatch(repo, req, id)
+	if err != nil {
+		if _, ok := err.(ValidationError); ok {
+			writeAPIInvalid(w, err)
+			return
+		}
+		if status == http.StatusInternalServerError {
+			log.Printf("Could not patch entry: %s", err)
+		}
+		writeAPIError(w, status, "%s", err)
+		return
+	}
+
+	apiRespondStored(repo, w, req, entry.ID, http.StatusOK)
+}
+
+func apiUpdate(repo Repository, w http.ResponseWriter, req *http.Request, entry *Entry) {
+	err := repo.Update(req.Context(), entry)
+	if err == ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, "no entry with id %q", entry.ID)
+		return
+	}
+	if err == ErrConflict {
+		writeAPIError(w, http.StatusPreconditionFailed, "entry %q has been changed", entry.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Could not update entry: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not update entry: %s", err)
+		return
+	}
+
+	apiRespondStored(repo, w, req, entry.ID, http.StatusOK)
+}
+
+func apiRespondStored(repo Repository, w http.ResponseWriter, req *http.Request, id string, status int) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil || entry == nil {
+		log.Printf("Could not get stored entry %q: %s", id, err)
+		writeAPIError(w, http.StatusInternalServerError, "could not get stored entry")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/entries/"+id)
+	w.Header().Set("ETag", entry.ETag())
+	writeAPIJSON(w, status, entry)
+}
+
+func apiDeleteEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, ok := apiLookupEntry(repo, w, req, id)
+	if !ok {
+		return
+	}
+
+	if !apiCheckVersion(w, req, entry, 0) {
+		return
+	}
+
+	err := repo.Delete(req.Context(), entry.ID)
+	if err != nil {
+		log.Printf("Could not delete entry: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not delete entry: %s", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
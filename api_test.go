@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestAPI(t *testing.T) http.Handler {
+	router := mux.NewRouter()
+	registerAPI(router, newTestRepository(t))
+	return router
+}
+
+func apiRequest(t *testing.T, api http.Handler, method, url, contentType, body string, headers ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, url, r)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	return rec
+}
+
+// expectAPIError checks that the response is an error in the shape the api
+// documents, `{"error": {"status": ..., "message": ...}}`.
+func expectAPIError(t *testing.T, rec *httptest.ResponseRecorder, status int) apiError {
+	t.Helper()
+
+	if rec.Code != status {
+		t.Fatalf("expected status %d, but got %d: %s", status, rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a json error, but got %q", ct)
+	}
+
+	var body map[string]apiError
+	dec := json.NewDecoder(rec.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err != nil {
+		t.Fatalf("invalid error response: %s", err)
+	}
+	apiErr, ok := body["error"]
+	if !ok || len(body) != 1 {
+		t.Fatalf("expected only an error, but got %#v", body)
+	}
+	if apiErr.Status != status || apiErr.Message == "" {
+		t.Errorf("unexpected error %#v", apiErr)
+	}
+	return apiErr
+}
+
+func decodeAPIEntry(t *testing.T, rec *httptest.ResponseRecorder, status int) Entry {
+	t.Helper()
+
+	if rec.Code != status {
+		t.Fatalf("expected status %d, but got %d: %s", status, rec.Code, rec.Body.String())
+	}
+	var entry Entry
+	err := json.Unmarshal(rec.Body.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("invalid json response: %s", err)
+	}
+	if etag := rec.Header().Get("ETag"); etag != entry.ETag() {
+		t.Errorf("expected ETag %s, but got %q", entry.ETag(), etag)
+	}
+	return entry
+}
+
+func TestAPIEntries(t *testing.T) {
+	api := newTestAPI(t)
+
+	rec := apiRequest(t, api, "POST", "/api/v1/entries", "application/json", `{"type":"mood","value":0.5,"note":"fine"}`)
+	created := decodeAPIEntry(t, rec, http.StatusCreated)
+	if created.ID == "" || created.Version != 1 || created.Date.IsZero() {
+		t.Fatalf("unexpected created entry %#v", created)
+	}
+	url := "/api/v1/entries/" + created.ID
+	if location := rec.Header().Get("Location"); location != url {
+		t.Errorf("expected Location %q, but got %q", url, location)
+	}
+
+	got := decodeAPIEntry(t, apiRequest(t, api, "GET", url, "", ""), http.StatusOK)
+	if got.ID != created.ID || got.Note != "fine" || got.Value != 0.5 {
+		t.Errorf("unexpected entry %#v", got)
+	}
+
+	rec = apiRequest(t, api, "GET", url, "", "", "If-None-Match", created.ETag())
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching If-None-Match, but got %d", rec.Code)
+	}
+
+	rec = apiRequest(t, api, "GET", "/api/v1/entries?type=mood", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, but got %d: %s", rec.Code, rec.Body.String())
+	}
+	var entries []Entry
+	err := json.Unmarshal(rec.Body.Bytes(), &entries)
+	if err != nil {
+		t.Fatalf("invalid json response: %s", err)
+	}
+	if len(entries) != 1 || entries[0].ID != created.ID {
+		t.Errorf("expected the created entry in the list, but got %#v", entries)
+	}
+
+	replaced := decodeAPIEntry(t, apiRequest(t, api, "PUT", url, "application/json", `{"value":0.75}`, "If-Match", created.ETag()), http.StatusOK)
+	if replaced.Value != 0.75 || replaced.Note != "" || replaced.Version != 2 {
+		t.Errorf("unexpected replaced entry %#v", replaced)
+	}
+	if replaced.Type != "mood" || !replaced.Date.Equal(created.Date) {
+		t.Errorf("expected date and type to be kept, but got %s and %q", replaced.Date, replaced.Type)
+	}
+
+	patched := decodeAPIEntry(t, apiRequest(t, api, "PATCH", url, "application/merge-patch+json", `{"note":"better"}`), http.StatusOK)
+	if patched.Value != 0.75 || patched.Note != "better" || patched.Version != 3 {
+		t.Errorf("unexpected patched entry %#v", patched)
+	}
+
+	patched = decodeAPIEntry(t, apiRequest(t, api, "PATCH", url, "application/json-patch+json", `[{"op":"replace","path":"/value","value":1}]`), http.StatusOK)
+	if patched.Value != 1 || patched.Version != 4 {
+		t.Errorf("unexpected patched entry %#v", patched)
+	}
+
+	rec = apiRequest(t, api, "DELETE", url, "", "", "If-Match", patched.ETag())
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	expectAPIError(t, apiRequest(t, api, "GET", url, "", ""), http.StatusNotFound)
+	expectAPIError(t, apiRequest(t, api, "DELETE", url, "", ""), http.StatusNotFound)
+}
+
+func TestAPIConflicts(t *testing.T) {
+	api := newTestAPI(t)
+
+	created := decodeAPIEntry(t, apiRequest(t, api, "POST", "/api/v1/entries", "application/json", `{"type":"mood","value":0.5}`), http.StatusCreated)
+	url := "/api/v1/entries/" + created.ID
+	decodeAPIEntry(t, apiRequest(t, api, "PUT", url, "application/json", `{"value":0.25}`), http.StatusOK)
+
+	// every change is checked against the outdated ETag of the created entry
+	expectAPIError(t, apiRequest(t, api, "PUT", url, "application/json", `{"value":0.1}`, "If-Match", created.ETag()), http.StatusPreconditionFailed)
+	expectAPIError(t, apiRequest(t, api, "PUT", url, "application/json", `{"value":0.1,"version":1}`), http.StatusPreconditionFailed)
+	expectAPIError(t, apiRequest(t, api, "PATCH", url, "application/merge-patch+json", `{"value":0.1}`, "If-Match", created.ETag()), http.StatusPreconditionFailed)
+	expectAPIError(t, apiRequest(t, api, "DELETE", url, "", "", "If-Match", created.ETag()), http.StatusPreconditionFailed)
+
+	got := decodeAPIEntry(t, apiRequest(t, api, "GET", url, "", ""), http.StatusOK)
+	if got.Value != 0.25 || got.Version != 2 {
+		t.Errorf("rejected changes should not change the entry, but got %#v", got)
+	}
+
+	decodeAPIEntry(t, apiRequest(t, api, "PUT", url, "application/json", `{"value":0.1}`, "If-Match", `W/"2", "3"`), http.StatusOK)
+}
+
+func TestAPIErrors(t *testing.T) {
+	api := newTestAPI(t)
+
+	created := decodeAPIEntry(t, apiRequest(t, api, "POST", "/api/v1/entries", "application/json", `{"type":"mood","value":0.5}`), http.StatusCreated)
+	url := "/api/v1/entries/" + created.ID
+
+	var testCases = []struct {
+		method      string
+		url         string
+		contentType string
+		body        string
+		status      int
+		field       string
+	}{
+		{"GET", "/api/v1/nothing", "", "", http.StatusNotFound, ""},
+		{"GET", "/api/v1/entries/doesnotexist", "", "", http.StatusNotFound, ""},
+		{"PUT", "/api/v1/entries/doesnotexist", "application/json", `{"value":1}`, http.StatusNotFound, ""},
+		{"PATCH", "/api/v1/entries/doesnotexist", "application/merge-patch+json", `{"value":1}`, http.StatusNotFound, ""},
+		{"GET", "/api/v1/entries?limit=many", "", "", http.StatusBadRequest, ""},
+		{"POST", "/api/v1/entries", "text/plain", `type=mood`, http.StatusUnsupportedMediaType, ""},
+		{"POST", "/api/v1/entries", "application/json", `{"type":"mood"`, http.StatusBadRequest, ""},
+		{"POST", "/api/v1/entries", "application/json", `{"type":"mood","mood":1}`, http.StatusBadRequest, ""},
+		{"POST", "/api/v1/entries", "application/json", `{"value":1}`, http.StatusBadRequest, ""},
+		{"POST", "/api/v1/entries", "application/json", `{"type":"mood","value":2}`, http.StatusUnprocessableEntity, "value"},
+		{"PUT", url, "application/json", `{"value":-1}`, http.StatusUnprocessableEntity, "value"},
+		{"PATCH", url, "application/merge-patch+json", `{"value":2}`, http.StatusUnprocessableEntity, "value"},
+		{"PATCH", url, "text/plain", `value=2`, http.StatusUnsupportedMediaType, ""},
+		{"PATCH", url, "application/json-patch+json", `[{"op":"remove","path":"/nothing"}]`, http.StatusUnprocessableEntity, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.url+" "+tc.body, func(t *testing.T) {
+			apiErr := expectAPIError(t, apiRequest(t, api, tc.method, tc.url, tc.contentType, tc.body), tc.status)
+			if tc.field != "" && apiErr.Fields[tc.field] == "" {
+				t.Errorf("expected a problem with the value, but got %#v", apiErr.Fields)
+			}
+		})
+	}
+
+	got := decodeAPIEntry(t, apiRequest(t, api, "GET", url, "", ""), http.StatusOK)
+	if got.Value != 0.5 || got.Version != 1 {
+		t.Errorf("rejected changes should not change the entry, but got %#v", got)
+	}
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	api := newTestAPI(t)
+
+	var testCases = []struct {
+		method string
+		url    string
+		allow  string
+	}{
+		{"PUT", "/api/v1/entries", "GET, POST"},
+		{"DELETE", "/api/v1/entries", "GET, POST"},
+		{"POST", "/api/v1/entries/abc", "GET, PUT, PATCH, DELETE"},
+		{"GET", "/api/v1/quick", "POST"},
+		{"POST", "/api/v1/tags", "GET"},
+	}
+
+	for _, tc := range testCases {
+		rec := apiRequest(t, api, tc.method, tc.url, "", "")
+		expectAPIError(t, rec, http.StatusMethodNotAllowed)
+		if allow := rec.Header().Get("Allow"); allow != tc.allow {
+			t.Errorf("%s %s: expected Allow %q, but got %q", tc.method, tc.url, tc.allow, allow)
+		}
+	}
+
+	rec := apiRequest(t, api, "PUT", "/api/v1/nothing", "", "")
+	expectAPIError(t, rec, http.StatusNotFound)
+	if allow := rec.Header().Get("Allow"); allow != "" {
+		t.Errorf("expected no Allow header for missing resources, but got %q", allow)
+	}
+}
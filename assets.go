@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+//go:embed static/*.css static/*.js
+var embeddedStatic embed.FS
+
+// migrationsFS contains the migrations compiled into the binary.
+var migrationsFS = mustSub(embeddedMigrations, "migrations")
+
+// staticAssets serves the stylesheets and scripts, either from the binary
+// or from the directory passed with -static-dir.
+var staticAssets = newAssets(mustSub(embeddedStatic, "static"), true)
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+type assets struct {
+	fs fs.FS
+
+	// files holds the contents and hashes of file systems that never
+	// change, which are read once by newAssets.  It is nil for the
+	// directory passed with -static-dir, which is read on every request.
+	files map[string]assetFile
+}
+
+type assetFile struct {
+	data []byte
+	hash string
+}
+
+func newAssets(fsys fs.FS, cache bool) *assets {
+	a := &assets{fs: fsys}
+	if !cache {
+		return a
+	}
+
+	a.files = map[string]assetFile{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		a.files[name] = assetFile{data: data, hash: contentHash(data)}
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("could not read static assets: %s", err))
+	}
+	return a
+}
+
+// Path returns the url of the named asset, including its content hash so
+// that browsers can cache it forever.
+func (a *assets) Path(name string) string {
+	f, err := a.file(name)
+	if err != nil {
+		return "/static/" + name
+	}
+	return "/static/" + name + "?v=" + f.hash
+}
+
+func (a *assets) file(name string) (assetFile, error) {
+	if a.files != nil {
+		f, ok := a.files[name]
+		if !ok {
+			return assetFile{}, fs.ErrNotExist
+		}
+		return f, nil
+	}
+
+	data, err := fs.ReadFile(a.fs, name)
+	if err != nil {
+		return assetFile{}, err
+	}
+	return assetFile{data: data, hash: contentHash(data)}, nil
+}
+
+func (a *assets) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+req.URL.Path), "/")
+
+	f, err := a.file(name)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+f.hash+`"`)
+	if req.URL.Query().Get("v") == f.hash {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	http.ServeContent(w, req, name, time.Time{}, bytes.NewReader(f.data))
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
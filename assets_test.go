@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssetsCacheHeaders(t *testing.T) {
+	fsys := fstest.MapFS{"app.js": {Data: []byte("console.log('hi');")}}
+	a := newAssets(fsys, true)
+
+	path := a.Path("app.js")
+	hash := contentHash(fsys["app.js"].Data)
+	if path != "/static/app.js?v="+hash {
+		t.Fatalf("expected the content hash in the path, but got %q", path)
+	}
+	if missing := a.Path("missing.js"); missing != "/static/missing.js" {
+		t.Errorf("expected missing assets without a hash, but got %q", missing)
+	}
+
+	var testCases = []struct {
+		url          string
+		status       int
+		cacheControl string
+	}{
+		{"/app.js?v=" + hash, http.StatusOK, "public, max-age=31536000, immutable"},
+		{"/app.js?v=outdated", http.StatusOK, "no-cache"},
+		{"/app.js", http.StatusOK, "no-cache"},
+		{"/missing.js", http.StatusNotFound, ""},
+		{"/", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, httptest.NewRequest("GET", tc.url, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, but got %d", tc.url, tc.status, rec.Code)
+			continue
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != tc.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, but got %q", tc.url, tc.cacheControl, cc)
+		}
+		if tc.status == http.StatusOK && rec.Header().Get("ETag") != `"`+hash+`"` {
+			t.Errorf("%s: unexpected ETag %q", tc.url, rec.Header().Get("ETag"))
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("If-None-Match", `"`+hash+`"`)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, but got %d", rec.Code)
+	}
+}
+
+func TestAssetsRehashWithoutCache(t *testing.T) {
+	fsys := fstest.MapFS{"app.css": {Data: []byte("body { color: red; }")}}
+	cached := newAssets(fsys, true)
+	uncached := newAssets(fsys, false)
+
+	before := cached.Path("app.css")
+	fsys["app.css"] = &fstest.MapFile{Data: []byte("body { color: blue; }")}
+
+	if after := cached.Path("app.css"); after != before {
+		t.Errorf("embedded assets should be hashed once, but %q changed to %q", before, after)
+	}
+	if after := uncached.Path("app.css"); after == before {
+		t.Errorf("assets from -static-dir should be hashed again after changes, but got %q", after)
+	}
+
+	rec := httptest.NewRecorder()
+	uncached.ServeHTTP(rec, httptest.NewRequest("GET", "/app.css", nil))
+	if !strings.Contains(rec.Body.String(), "blue") {
+		t.Errorf("expected the changed content, but got %q", rec.Body.String())
+	}
+}
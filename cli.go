@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// commands are the subcommands, the server is started if none is given.
+var commands = map[string]func(repo Repository, args []string) int{
+	"add":    runAdd,
+	"quick":  runQuickAdd,
+	"list":   runList,
+	"query":  runQuery,
+	"export": runExport,
+	"import": runImport,
+	"serve":  runServe,
+}
+
+// runCommand runs a subcommand given on the command line, returning the
+// exit code.
+func runCommand(repo Repository, args []string) int {
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, must be one of add, quick, list, query, export, import or serve\n", args[0])
+		return 2
+	}
+	return command(repo, args[1:])
+}
+
+// newFlagSet creates the flags for a command, with usage showing the
+// positional arguments.
+func newFlagSet(name string, positional string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s [%s flags] %s\n", filepath.Base(os.Args[0]), name, name, positional)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags that may come before, between or after the
+// positional arguments, which are returned.  Numbers are always
+// positional, so that negative values can be given.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for len(args) > 0 {
+		if _, err := parseNumber(args[0]); err == nil {
+			positional = append(positional, args[0])
+			args = args[1:]
+			continue
+		}
+
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) > 0 {
+			positional = append(positional, args[0])
+			args = args[1:]
+		}
+	}
+	return positional, nil
+}
+
+// keyValues collects repeated key=value flags.
+type keyValues []string
+
+func (kvs *keyValues) String() string {
+	return strings.Join(*kvs, ", ")
+}
+
+func (kvs *keyValues) Set(kv string) error {
+	if !strings.Contains(kv, "=") {
+		return fmt.Errorf("%q must be key=value", kv)
+	}
+	*kvs = append(*kvs, kv)
+	return nil
+}
+
+func (kvs keyValues) Values() url.Values {
+	values := url.Values{}
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		values.Add(parts[0], parts[1])
+	}
+	return values
+}
+
+func runAdd(repo Repository, args []string) int {
+	fs := newFlagSet("add", "<type> [value]")
+	note := fs.String("note", "", "Note for the entry, #tags in it are added to the tags")
+	tags := fs.String("tags", "", "Tags for the entry, comma-separated")
+	date := fs.String("date", "", "Date of the entry, e.g. 2019-10-01T08:30 (UTC) or RFC 3339 (default now)")
+	output := fs.String("output", "table", "Output format, table, json or csv")
+	var data keyValues
+	fs.Var(&data, "data", "Additional data as key=value, can be repeated")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		fs.Usage()
+		return 2
+	}
+
+	entry := Entry{
+		Date: time.Now(),
+		Type: positional[0],
+		Note: *note,
+		Tags: parseTags(*tags),
+	}
+	if len(positional) == 2 {
+		entry.Value, err = parseNumber(positional[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "value %q is not a number\n", positional[1])
+			return 2
+		}
+	}
+	if *date != "" {
+		entry.Date, err = parseFormDate(*date)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid date %q: %s\n", *date, err)
+			return 2
+		}
+	}
+	td := entryTypes.Get(entry.Type)
+	for key, vals := range data.Values() {
+		if entry.Data == nil {
+			entry.Data = map[string]interface{}{}
+		}
+		if val, ok := td.ParseField(key, vals); ok {
+			entry.Data[key] = val
+		}
+	}
+
+	err = ValidateEntry(&entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid entry: %s\n", err)
+		return 2
+	}
+
+	entry.ID, err = repo.Create(context.Background(), &entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not add entry: %s\n", err)
+		return 1
+	}
+	entry.Version = 1
+
+	return writeEntries(os.Stdout, *output, Entries{entry})
+}
+
+func runQuickAdd(repo Repository, args []string) int {
+	fs := newFlagSet("quick", "<text, e.g. coffee 2 @08:30 #work oat milk>")
+	preview := fs.Bool("preview", false, "Only show the parsed entry without saving it")
+	output := fs.String("output", "table", "Output format, table, json or csv")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// arguments quoted in the shell stay together
+	for i, arg := range positional {
+		if strings.ContainsAny(arg, " \t") && !strings.Contains(arg, `"`) {
+			if idx := strings.Index(arg, "="); idx > 0 && !strings.ContainsAny(arg[:idx], " \t") {
+				positional[i] = arg[:idx+1] + `"` + arg[idx+1:] + `"`
+			} else {
+				positional[i] = `"` + arg + `"`
+			}
+		}
+	}
+
+	entry, err := ParseQuickAdd(strings.Join(positional, " "), time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	err = ValidateEntry(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid entry: %s\n", err)
+		return 2
+	}
+
+	if !*preview {
+		entry.ID, err = repo.Create(context.Background(), entry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not add entry: %s\n", err)
+			return 1
+		}
+		entry.Version = 1
+	}
+
+	return writeEntries(os.Stdout, *output, Entries{*entry})
+}
+
+func runList(repo Repository, args []string) int {
+	return listEntries(repo, "list", args, 50, Descending, "table")
+}
+
+func runExport(repo Repository, args []string) int {
+	return listEntries(repo, "export", args, 0, Ascending, "csv")
+}
+
+// listEntries implements list and export, which only differ in their
+// defaults.
+func listEntries(repo Repository, name string, args []string, limit int, order order, output string) int {
+	fs := newFlagSet(name, "")
+	since := fs.String("since", "", "Only entries since then, e.g. 7d, 12h or 2019-10-01")
+	until := fs.String("until", "", "Only entries until then, e.g. 1d or 2019-10-31")
+	types := fs.String("type", "", "Only entries of these types, comma-separated")
+	tags := fs.String("tag", "", "Only entries with all of these tags, comma-separated")
+	fs.IntVar(&limit, "limit", limit, "Maximum number of entries, 0 for all")
+	asc := fs.Bool("asc", order == Ascending, "Oldest entries first")
+	fs.StringVar(&output, "output", output, "Output format, table, json or csv")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	filter := Filter{Limit: limit, Order: Descending}
+	if *asc {
+		filter.Order = Ascending
+	}
+	now := time.Now()
+	filter.From, err = parseSince(*since, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -since: %s\n", err)
+		return 2
+	}
+	filter.To, err = parseUntil(*until, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -until: %s\n", err)
+		return 2
+	}
+	for _, typ := range strings.Split(*types, ",") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			filter.Types = append(filter.Types, typ)
+		}
+	}
+	filter.Tags = parseTags(*tags)
+
+	ctx := context.Background()
+	if output == "csv" {
+		var cw *CSVWriter
+		err = repo.Each(ctx, filter, func(keys []string) error {
+			var err error
+			cw, err = NewCSVWriter(os.Stdout, keys)
+			return err
+		}, func(entry *Entry) error {
+			return cw.Write(*entry)
+		})
+		if err == nil {
+			err = cw.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not export entries: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	entries, err := repo.Find(ctx, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not list entries: %s\n", err)
+		return 1
+	}
+	return writeEntries(os.Stdout, output, entries)
+}
+
+// parseSince parses durations into the past like 7d, 2w or 12h, or dates.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	days := map[byte]int{'d': 1, 'w': 7}
+	if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && days[s[len(s)-1]] > 0 {
+		return now.AddDate(0, 0, -n*days[s[len(s)-1]]), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return parseImportDate(s)
+}
+
+// parseUntil parses the end of a range like parseSince, but a plain date
+// includes the whole day.
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	t, err := parseSince(s, now)
+	if err != nil || !isPlainDate(s) {
+		return t, err
+	}
+	return endOfDay(t), nil
+}
+
+// writeEntries writes the entries as an aligned table, JSON or CSV.
+func writeEntries(w io.Writer, output string, entries Entries) int {
+	var err error
+	switch output {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tDATE\tTYPE\tVALUE\tNOTE\tTAGS\tDATA")
+		for _, e := range entries {
+			data := ""
+			if len(e.Data) > 0 {
+				buf, _ := json.Marshal(e.Data)
+				data = string(buf)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Date.UTC().Format("2006-01-02 15:04"), e.Type,
+				strconv.FormatFloat(e.Value, 'f', -1, 64), oneLine(e.Note), strings.Join(e.Tags, ","), data)
+		}
+		err = tw.Flush()
+	case "json":
+		err = entries.RenderJSON(w)
+	case "csv":
+		err = entries.RenderCSV(w)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q, must be table, json or csv\n", output)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not write entries: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func oneLine(s string) string {
+	return strings.Replace(s, "\n", " ", -1)
+}
+
+func runQuery(repo Repository, args []string) int {
+	fs := newFlagSet("query", "<sql>")
+	output := fs.String("output", "table", "Output format, table, json or csv")
+	saved := fs.String("saved", "", "Run the saved query with this name instead")
+	var params keyValues
+	fs.Var(&params, "param", "Parameter for a saved query as name=value, can be repeated")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+	if (*saved == "" && len(positional) != 1) || (*saved != "" && len(positional) != 0) {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.queryTimeout)
+	defer cancel()
+
+	var table *Table
+	if *saved != "" {
+		query, err := repo.SavedQuery(ctx, *saved)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if query == nil {
+			fmt.Fprintf(os.Stderr, "no saved query %q\n", *saved)
+			return 1
+		}
+		queryArgs, err := query.Args(params.Values())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		table, err = repo.QueryTable(ctx, query.Query, queryArgs...)
+	} else {
+		table, err = repo.QueryTable(ctx, positional[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch *output {
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		names := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			names[i] = column.Name
+		}
+		fmt.Fprintln(tw, strings.Join(names, "\t"))
+		for _, row := range table.Rows {
+			vals := make([]string, len(row))
+			for i, val := range row {
+				vals[i] = oneLine(formatValue(val))
+				if val == nil {
+					vals[i] = "NULL"
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(vals, "\t"))
+		}
+		err = tw.Flush()
+		if table.Truncated {
+			fmt.Fprintf(os.Stderr, "only the first %d of %d rows are shown\n", len(table.Rows), table.RowCount)
+		}
+	case "json":
+		err = table.RenderJSON(os.Stdout)
+	case "csv":
+		err = table.RenderCSV(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q, must be table, json or csv\n", *output)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not write result: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runServe(repo Repository, args []string) int {
+	fs := newFlagSet("serve", "")
+	addr := fs.String("addr", config.addr, "Address to listen on")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	err = serve(repo, *addr)
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+func runImport(repo Repository, args []string) int {
+	fs := newFlagSet("import", "<file or ->")
+	format := fs.String("format", "", "Format of the file, one of "+strings.Join(importFormats(), ", ")+" (default csv or json from the file name)")
+	mapping := fs.String("map", "", "Mapping of CSV columns to fields, e.g. Datum=date,Was=type,Ort=data.location")
+	typ := fs.String("type", "", "Type for entries that don't have one")
+	dryRun := fs.Bool("dry-run", false, "Only check the file and report what would be imported")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	opts := ImportOptions{
+		Format: *format,
+		Type:   *typ,
+		DryRun: *dryRun,
+	}
+	if opts.Format == "" {
+		opts.Format = importFormat("", positional[0])
+	}
+	opts.Mapping, err = parseMapping(*mapping)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	result, err := Import(context.Background(), repo, r, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not import: %s\n", err)
+		return 1
+	}
+
+	if len(result.Errors) > 0 {
+		fmt.Fprintln(os.Stderr, "Nothing was imported, fix these problems first:")
+		for _, err := range result.Errors {
+			fmt.Fprintf(os.Stderr, "  %s\n", err)
+		}
+		return 1
+	}
+
+	verb := "Imported"
+	if result.DryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d of %d entries.\n", verb, result.Imported, result.Total)
+	if len(result.Duplicates) > 0 {
+		rows := make([]string, len(result.Duplicates))
+		for i, row := range result.Duplicates {
+			rows[i] = fmt.Sprint(row)
+		}
+		fmt.Printf("Skipped duplicates in rows %s.\n", strings.Join(rows, ", "))
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	note := fs.String("note", "", "")
+	var data keyValues
+	fs.Var(&data, "data", "")
+
+	positional, err := parseArgs(fs, []string{"mood", "-0.5", "--note", "tired but ok", "-data", "with=alice", "-data=with=bob"})
+	if err != nil {
+		t.Fatalf("could not parse args: %s", err)
+	}
+	if !reflect.DeepEqual(positional, []string{"mood", "-0.5"}) {
+		t.Errorf("unexpected positional args %v", positional)
+	}
+	if *note != "tired but ok" {
+		t.Errorf("unexpected note %q", *note)
+	}
+	if with := data.Values()["with"]; !reflect.DeepEqual(with, []string{"alice", "bob"}) {
+		t.Errorf("unexpected data %v", with)
+	}
+}
+
+func TestRunAddValue(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, value := range []string{"nan", "-Inf", "infinity", "many"} {
+		if code := runAdd(repo, []string{"mood", value}); code != 2 {
+			t.Errorf("%s: expected exit code 2, but got %d", value, code)
+		}
+	}
+
+	entries, err := repo.Find(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("invalid values should not be added, but got %#v", entries)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2019, 10, 8, 12, 0, 0, 0, time.UTC)
+	var testCases = []struct {
+		since    string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"7d", time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)},
+		{"1w", time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)},
+		{"90m", time.Date(2019, 10, 8, 10, 30, 0, 0, time.UTC)},
+		{"2019-10-01", time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		since, err := parseSince(tc.since, now)
+		if err != nil {
+			t.Errorf("%q: %s", tc.since, err)
+			continue
+		}
+		if !since.Equal(tc.expected) {
+			t.Errorf("%q: expected %s, but got %s", tc.since, tc.expected, since)
+		}
+	}
+
+	_, err := parseSince("last week", now)
+	if err == nil {
+		t.Errorf("expected an error for %q", "last week")
+	}
+}
+
+func TestUndeclaredFieldsAsText(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	if code := runAdd(repo, []string{"-data", "cups=123", "-data", "cold=true", "coffee"}); code != 0 {
+		t.Fatalf("could not add entry, exit code %d", code)
+	}
+	added, err := repo.Find(ctx, Filter{})
+	if err != nil || len(added) != 1 {
+		t.Fatalf("expected the added entry, but got %v (%v)", added, err)
+	}
+
+	quick, err := ParseQuickAdd("coffee cups=123 cold=true", time.Now())
+	if err != nil {
+		t.Fatalf("could not parse quick add: %s", err)
+	}
+
+	csv := "date,type,cups,cold\n2019-10-01,coffee,123,true\n"
+	imported, _, errs, err := ReadImport(strings.NewReader(csv), ImportOptions{Format: "csv"})
+	if err != nil || len(errs) > 0 || len(imported) != 1 {
+		t.Fatalf("could not read import: %v %v", err, errs)
+	}
+
+	expected := map[string]interface{}{"cups": "123", "cold": "true"}
+	for name, data := range map[string]map[string]interface{}{
+		"daily add": added[0].Data,
+		"quick add": quick.Data,
+		"import":    imported[0].Data,
+	} {
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("%s: expected %#v, but got %#v", name, expected, data)
+		}
+	}
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -22,45 +28,170 @@ type Entry struct {
 	Note  string                 `json:"note,omitempty"`
 	Value float64                `json:"value"`
 	Data  map[string]interface{} `json:"data,omitempty"`
+	Tags  []string               `json:"tags,omitempty"`
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int64      `json:"version,omitempty"`
 }
 
 type Entries []Entry
 
+// OfType returns the entries with one of the given types.
+func (es Entries) OfType(types ...string) Entries {
+	filtered := make(Entries, 0, len(es))
+	for _, e := range es {
+		for _, typ := range types {
+			if e.Type == typ {
+				filtered = append(filtered, e)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// idPattern matches the ids generated by the repository, so that /{id}
+// doesn't match any other path.
+const idPattern = "{id:[A-Za-z0-9_-]{16}}"
+
 var config struct {
-	addr   string
-	dbName string
+	addr         string
+	dbName       string
+	migrateOnly  bool
+	staticDir    string
+	typesDir     string
+	queryTimeout time.Duration
 }
 
 func main() {
 	flag.StringVar(&config.addr, "addr", "localhost:11111", "Address to listen on")
 	flag.StringVar(&config.dbName, "db", "./test.db", "Path to the database to use")
+	flag.BoolVar(&config.migrateOnly, "migrate-only", false, "Migrate the database schema and exit")
+	flag.DurationVar(&config.queryTimeout, "query-timeout", 10*time.Second, "Maximum time a query from /query may take")
+	flag.StringVar(&config.staticDir, "static-dir", "", "Serve static assets from this directory instead of the embedded ones (for development)")
+	flag.StringVar(&config.typesDir, "types-dir", "", "Directory with additional type definitions, reloaded when they change (default: types next to -db)")
 	flag.Parse()
 
+	if config.typesDir == "" {
+		config.typesDir = filepath.Join(filepath.Dir(config.dbName), "types")
+	}
+
+	if config.staticDir != "" {
+		staticAssets = newAssets(os.DirFS(config.staticDir), false)
+	}
+
+	// invalid definitions are reported by Watch again once they change,
+	// until then the builtin ones are used
+	err := entryTypes.Load(config.typesDir)
+	if err != nil {
+		log.Printf("Could not load type definitions: %s", err)
+	}
+	go entryTypes.Watch(2 * time.Second)
+
 	log.Printf("Opening database %q", config.dbName)
-	repo, err := NewRepository(config.dbName, "./schema-init.sql")
+	repo, err := NewRepository(config.dbName, migrationsFS)
 	if err != nil {
 		log.Fatalf("Failed to open database %q: %s", config.dbName, err)
 	}
 
+	if config.migrateOnly {
+		return
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	os.Exit(runCommand(repo, args))
+}
+
+// serve serves the html pages and the api on addr.
+func serve(repo Repository, addr string) error {
 	router := mux.NewRouter()
 
+	registerAPI(router, repo)
+
 	router.Methods("GET").Path("/").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		renderEntries(repo, w, req)
 	})
 
 	router.Methods("GET").Path("/new").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		RenderInput(w, req, "")
+		RenderInput(w, req, &Entry{}, nil)
 	})
 
 	router.Methods("GET").Path("/new/{type}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		RenderInput(w, req, mux.Vars(req)["type"])
+		RenderInput(w, req, entryTypes.Get(mux.Vars(req)["type"]).NewEntry(), nil)
+	})
+
+	router.Methods("GET").Path("/trash").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderTrash(repo, w, req)
 	})
 
 	router.Methods("GET").Path("/query").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		renderQuery(repo, w, req)
 	})
 
-	router.Methods("GET").Path("/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	router.Methods("GET").Path("/quick").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderQuickAdd(w, req)
+	})
+
+	router.Methods("POST").Path("/quick").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		quickAdd(repo, w, req)
+	})
+
+	router.Methods("GET", "POST").Path("/import").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderImport(repo, w, req)
+	})
+
+	router.Methods("GET").Path("/search").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderSearch(repo, w, req)
+	})
+
+	router.Methods("GET").Path("/types").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderTypes(repo, w, req)
+	})
+
+	router.Methods("POST").Path("/types").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		createType(repo, w, req)
+	})
+
+	router.Methods("GET").Path("/types/{type}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderType(repo, w, req, mux.Vars(req)["type"], nil, http.StatusOK, nil)
+	})
+
+	router.Methods("POST").Path("/types/{type}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		saveType(repo, w, req, mux.Vars(req)["type"])
+	})
+
+	router.Methods("POST").Path("/types/{type}/rename").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renameType(repo, w, req, mux.Vars(req)["type"])
+	})
+
+	router.Methods("GET").Path("/tags").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderTags(repo, w, req)
+	})
+
+	router.Methods("GET").Path("/tags/{tag}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderTagEntries(repo, w, req, mux.Vars(req)["tag"])
+	})
+
+	router.Methods("GET").Path("/queries").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderSavedQueries(repo, w, req)
+	})
+
+	router.Methods("POST").Path("/queries").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		saveQuery(repo, w, req)
+	})
+
+	router.Methods("GET").Path("/query/{name}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		runSavedQuery(repo, w, req, mux.Vars(req)["name"])
+	})
+
+	router.Methods("POST").Path("/query/{name}/delete").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deleteSavedQuery(repo, w, req, mux.Vars(req)["name"])
+	})
+
+	router.Methods("GET").Path("/" + idPattern).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		renderEntry(repo, mux.Vars(req)["id"], w, req)
 	})
 
@@ -68,24 +199,67 @@ func main() {
 		createEntry(repo, w, req)
 	})
 
-	router.Methods("POST").Path("/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	router.Methods("POST").Path("/" + idPattern).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		editEntry(repo, w, req, mux.Vars(req)["id"])
 	})
 
-	router.Methods("GET").Path("/{id}/edit").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	router.Methods("PATCH").Path("/" + idPattern).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		patchEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	router.Methods("DELETE").Path("/" + idPattern).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deleteEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	router.Methods("POST").Path("/" + idPattern + "/delete").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deleteEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	router.Methods("POST").Path("/" + idPattern + "/restore").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		restoreEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	router.Methods("POST").Path("/" + idPattern + "/purge").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		purgeEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	router.Methods("GET").Path("/" + idPattern + "/history").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderHistory(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	router.Methods("POST").Path("/" + idPattern + "/revert").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		revertEntry(repo, w, req, mux.Vars(req)["id"])
+	})
+
+	router.Methods("GET").Path("/" + idPattern + "/edit").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		renderEditEntry(repo, w, req, mux.Vars(req)["id"])
 	})
 
 	http.Handle("/", router)
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	http.Handle("/static/", http.StripPrefix("/static/", staticAssets))
 
-	log.Printf("Listening on http://%s", config.addr)
-	log.Fatal(http.ListenAndServe(config.addr, nil))
+	log.Printf("Listening on http://%s", addr)
+	return http.ListenAndServe(addr, nil)
 }
 
 func renderEntries(repo Repository, w http.ResponseWriter, req *http.Request) {
-	now := time.Now().UTC()
-	entries, err := repo.FindBetween(req.Context(), now.AddDate(0, 0, -30), now, Descending)
+	q, err := parseListQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if resultFormat(req) == "csv" {
+		// exports contain all entries in the range instead of a page
+		filter := Filter{From: q.From, To: q.To, Types: q.Types, Tags: q.Tags, Order: q.Order}
+		if req.URL.Query().Get("limit") != "" {
+			filter.Limit = q.Limit
+		}
+		writeEntriesCSV(repo, w, req, filter)
+		return
+	}
+
+	page, err := repo.List(req.Context(), q)
 	if err != nil {
 		log.Printf("Could not list entries: %s", err)
 		http.Error(w, fmt.Sprintf("could not list entries: %s", err), http.StatusInternalServerError)
@@ -93,15 +267,66 @@ func renderEntries(repo Repository, w http.ResponseWriter, req *http.Request) {
 	}
 
 	buf := new(bytes.Buffer)
-	err = entries.Render(buf, req.Header.Get("Accept"))
+	if strings.Contains(req.Header.Get("Accept"), "html") {
+		err = page.RenderHTML(buf, req.URL, q)
+	} else {
+		if links := linkHeader(req.URL, page); links != "" {
+			w.Header().Set("Link", links)
+		}
+		err = page.Entries.RenderJSON(buf)
+	}
 	if err != nil {
 		log.Printf("Could not render entries: %s", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	io.Copy(w, buf)
+	writeWithETag(w, req, "", buf)
+}
+
+// writeEntriesCSV streams the entries matching the filter as CSV.
+func writeEntriesCSV(repo Repository, w http.ResponseWriter, req *http.Request, filter Filter) {
+	var cw *CSVWriter
+	err := repo.Each(req.Context(), filter, func(keys []string) error {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		var err error
+		cw, err = NewCSVWriter(w, keys)
+		return err
+	}, func(entry *Entry) error {
+		return cw.Write(*entry)
+	})
+	if err == nil {
+		err = cw.Flush()
+	}
+	if err != nil {
+		log.Printf("Could not export entries: %s", err)
+		if cw == nil {
+			http.Error(w, fmt.Sprintf("could not export entries: %s", err), http.StatusInternalServerError)
+		}
+	}
+}
+
+func renderTrash(repo Repository, w http.ResponseWriter, req *http.Request) {
+	entries, err := repo.FindDeleted(req.Context())
+	if err != nil {
+		log.Printf("Could not list deleted entries: %s", err)
+		http.Error(w, fmt.Sprintf("could not list deleted entries: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if strings.Contains(req.Header.Get("Accept"), "html") {
+		err = entries.RenderTrashHTML(buf)
+	} else {
+		err = entries.RenderJSON(buf)
+	}
+	if err != nil {
+		log.Printf("Could not render entries: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
 
+	io.Copy(w, buf)
 }
 
 func renderEntry(repo Repository, id string, w http.ResponseWriter, req *http.Request) {
@@ -125,23 +350,73 @@ func renderEntry(repo Repository, id string, w http.ResponseWriter, req *http.Re
 		return
 	}
 
-	io.Copy(w, buf)
+	writeWithETag(w, req, entry.ETag(), buf)
 }
 
+// renderQuery runs either the SQL in the `query` parameter on a read-only
+// connection, or the structured filter described by the other
+// parameters, see parseFilter.  Results are rendered as html, JSON or CSV.
 func renderQuery(repo Repository, w http.ResponseWriter, req *http.Request) {
+	var table *Table = nil
 	var entries Entries = nil
 	var err error
 
-	query := req.URL.Query().Get("query")
+	ctx, cancel := context.WithTimeout(req.Context(), config.queryTimeout)
+	defer cancel()
+
+	params := req.URL.Query()
+	format := resultFormat(req)
+	query := params.Get("query")
 	if query != "" {
-		entries, err = repo.Query(req.Context(), query)
+		table, err = repo.QueryTable(ctx, query)
 		if err != nil {
 			log.Printf("Could not execute query: %s", err)
 		}
+	} else if !isFilterEmpty(params) {
+		var filter Filter
+		filter, err = parseFilter(params)
+		if err == nil && format == "csv" {
+			// exports aren't limited unless asked to
+			if params.Get("limit") == "" {
+				filter.Limit = 0
+			}
+			writeEntriesCSV(repo, w, req, filter)
+			return
+		}
+		if err == nil {
+			entries, err = repo.Find(ctx, filter)
+			if err != nil {
+				log.Printf("Could not find entries: %s", err)
+			}
+		}
+	}
+
+	if format != "html" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if table != nil {
+			err = writeTable(w, format, table)
+		} else if format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			err = entries.RenderJSON(w)
+		} else {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = entries.RenderCSV(w)
+		}
+		if err != nil {
+			log.Printf("Could not render result: %s", err)
+		}
+		return
 	}
 
 	err = tmplQuery.Execute(w, map[string]interface{}{
 		"Query":   query,
+		"Save":    SavedQuery{Query: query, Params: paramsOf(query)},
+		"Filter":  params,
+		"Table":   table,
 		"Entries": entries,
 		"Error":   err,
 	})
@@ -151,7 +426,7 @@ func renderQuery(repo Repository, w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-var tmplQuery = template.Must(template.New("query").Parse(`<!doctype html>
+var tmplQuery = template.Must(tmplTable.New("query").Parse(`<!doctype html>
 <html>
 <head>
 	<meta charset="utf-8" />
@@ -160,6 +435,35 @@ var tmplQuery = template.Must(template.New("query").Parse(`<!doctype html>
 
 <body>
 	<form method="GET" action="/query">
+		{{ with .Filter }}
+		<div>
+			<input name="type" placeholder="types, comma-separated" value="{{ .Get "type" }}" />
+			<input name="from" type="date" value="{{ .Get "from" }}" />
+			<input name="to" type="date" value="{{ .Get "to" }}" />
+		</div>
+		<div>
+			<input name="min" type="number" step="any" placeholder="min value" value="{{ .Get "min" }}" />
+			<input name="max" type="number" step="any" placeholder="max value" value="{{ .Get "max" }}" />
+			<input name="note" placeholder="note contains" value="{{ .Get "note" }}" />
+		</div>
+		<div>
+			{{ range (index . "data") }}
+			<input name="data" value="{{ . }}" />
+			{{ end }}
+			<input name="data" placeholder="location=home, cups>=2, milk" />
+		</div>
+		<div>
+			<select name="order">
+				<option value="desc">newest first</option>
+				<option value="asc" {{ if eq (.Get "order") "asc" }}selected{{ end }}>oldest first</option>
+			</select>
+			<input name="limit" type="number" min="1" placeholder="limit" value="{{ .Get "limit" }}" />
+			<label><input type="checkbox" name="deleted" value="1" {{ if .Get "deleted" }}checked{{ end }} /> Include deleted entries</label>
+		</div>
+		{{ end }}
+
+		<p>or SQL (read-only, deleted entries have <code>deleted_at</code> set):</p>
+
 		<div>
 			<textarea name="query" cols="80" rows="10">{{ .Query }}</textarea>
 		</div>
@@ -167,27 +471,59 @@ var tmplQuery = template.Must(template.New("query").Parse(`<!doctype html>
 		<input type="submit" value="Search!" />
 	</form>
 
+	<a href="/queries">Saved queries</a>
+
 	{{ if .Error }}
 	<div class="error">
 		<pre>{{ .Error }}</pre>
 	</div>
 	{{ end }}
 
+	{{ if .Table }}
+	{{ template "table" .Table }}
+	<a href="?{{ .Filter.Encode }}&amp;format=csv">csv</a>
+	<a href="?{{ .Filter.Encode }}&amp;format=json">json</a>
+	{{ else }}
 	<div class="result">
 		<pre>{{ .Entries.RenderJSONString }}</pre>
 	</div>
+	{{ if .Entries }}
+	<a href="?{{ .Filter.Encode }}&amp;format=csv">csv</a>
+	{{ end }}
+	{{ end }}
+
+	{{ if .Query }}
+	<details>
+		<summary>Save this query</summary>
+		{{ template "save-query" .Save }}
+	</details>
+	{{ end }}
 </body>
 </html>
 `))
 
 func createEntry(repo Repository, w http.ResponseWriter, req *http.Request) {
-	entry, err := FromPostForm(req)
+	entry, err := FromRequest(req)
 	if err != nil {
 		log.Printf("Could not parse entry: %s", err)
 		http.Error(w, fmt.Sprintf("Could not parse entry: %s", err), http.StatusBadRequest)
 		return
 	}
 
+	if entry.Date.IsZero() {
+		entry.Date = time.Now().UTC().Round(time.Millisecond)
+	}
+
+	err = ValidateEntry(entry)
+	if err != nil {
+		if wantsJSON(req) {
+			http.Error(w, fmt.Sprintf("Invalid entry: %s", err), http.StatusUnprocessableEntity)
+			return
+		}
+		RenderInput(w, req, entry, err.(ValidationError))
+		return
+	}
+
 	id, err := repo.Create(req.Context(), entry)
 	if err != nil {
 		log.Printf("Could not create entry: %s", err)
@@ -196,6 +532,10 @@ func createEntry(repo Repository, w http.ResponseWriter, req *http.Request) {
 	}
 
 	w.Header().Set("Location", "/"+id)
+	if wantsJSON(req) {
+		respondStoredEntry(repo, w, req, id, http.StatusCreated)
+		return
+	}
 	w.WriteHeader(http.StatusFound)
 }
 
@@ -207,12 +547,20 @@ func renderEditEntry(repo Repository, w http.ResponseWriter, req *http.Request,
 		return
 	}
 
-	if entry == nil {
+	if entry == nil || entry.DeletedAt != nil {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
 
-	RenderEdit(w, req, entry)
+	types, err := repo.Types(req.Context())
+	if err != nil {
+		log.Printf("Could not list types: %s", err)
+		http.Error(w, fmt.Sprintf("Could not list types: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", entry.ETag())
+	RenderEdit(w, req, entry, types, nil)
 }
 
 func editEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
@@ -223,42 +571,440 @@ func editEntry(repo Repository, w http.ResponseWriter, req *http.Request, id str
 		return
 	}
 
-	if entry == nil {
+	if entry == nil || entry.DeletedAt != nil {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
 
-	editedEntry, err := FromPostForm(req)
+	editedEntry, err := FromRequest(req)
 	if err != nil {
 		log.Printf("Could not parse entry: %s", err)
 		http.Error(w, fmt.Sprintf("Could not parse entry: %s", err), http.StatusBadRequest)
 		return
 	}
 
+	// the edit form sends the version it was rendered with, scripts can
+	// send If-Match instead
+	if !ifMatch(req, entry.ETag()) || (editedEntry.Version != 0 && editedEntry.Version != entry.Version) {
+		http.Error(w, "The entry has been changed in the meantime, reload and try again.", http.StatusPreconditionFailed)
+		return
+	}
+
 	editedEntry.ID = entry.ID
-	editedEntry.Date = entry.Date
-	editedEntry.Type = entry.Type
+	editedEntry.Version = entry.Version
+	keepDateAndType(editedEntry, entry)
+	if !isJSON(req) {
+		keepDataTypes(editedEntry, entry)
+	}
+
+	err = ValidateEntry(editedEntry)
+	if err != nil {
+		if wantsJSON(req) {
+			http.Error(w, fmt.Sprintf("Invalid entry: %s", err), http.StatusUnprocessableEntity)
+			return
+		}
+		types, typesErr := repo.Types(req.Context())
+		if typesErr != nil {
+			log.Printf("Could not list types: %s", typesErr)
+		}
+		RenderEdit(w, req, editedEntry, types, err.(ValidationError))
+		return
+	}
 
 	err = repo.Update(req.Context(), editedEntry)
+	if err == ErrNotFound {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err == ErrConflict {
+		http.Error(w, "The entry has been changed in the meantime, reload and try again.", http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
 		log.Printf("Could not update entry: %s", err)
 		http.Error(w, fmt.Sprintf("Could not update entry: %s", err), http.StatusInternalServerError)
 		return
 	}
 
+	if wantsJSON(req) {
+		respondStoredEntry(repo, w, req, entry.ID, http.StatusOK)
+		return
+	}
+	w.Header().Set("Location", "/"+entry.ID)
+	w.WriteHeader(http.StatusFound)
+}
+
+// keepDateAndType uses the stored date and type for an edit that doesn't
+// specify them.
+func keepDateAndType(edited, stored *Entry) {
+	// the date picker only has second precision, so an unchanged date
+	// would otherwise lose its milliseconds
+	if edited.Date.IsZero() || edited.Date.Equal(stored.Date.Truncate(time.Second)) {
+		edited.Date = stored.Date
+	}
+	if edited.Type == "" {
+		edited.Type = stored.Type
+	}
+}
+
+// keepDataTypes keeps stored values of undeclared fields that the edit
+// form sent back unchanged, which would otherwise be turned into text.
+func keepDataTypes(edited, stored *Entry) {
+	td := entryTypes.Get(edited.Type)
+	for key, val := range edited.Data {
+		storedVal, ok := stored.Data[key]
+		if !ok || td.Field(key) != nil {
+			continue
+		}
+
+		if formText(storedVal) == formText(val) {
+			edited.Data[key] = storedVal
+		}
+	}
+}
+
+// formText returns values as they are shown in the edit form.
+func formText(val interface{}) string {
+	if list, ok := val.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, "\x00")
+	}
+	return fmt.Sprint(val)
+}
+
+// patchEntry applies a JSON Merge Patch or JSON Patch to an entry and
+// responds with the changed entry.
+func patchEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, status, err := applyRequestPatch(repo, req, id)
+	if err != nil {
+		if _, ok := err.(ValidationError); ok {
+			http.Error(w, fmt.Sprintf("Invalid entry: %s", err), status)
+			return
+		}
+		if status == http.StatusInternalServerError {
+			log.Printf("Could not patch entry: %s", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	respondStoredEntry(repo, w, req, entry.ID, http.StatusOK)
+}
+
+// applyRequestPatch applies the patch in the body of the request to the
+// entry with the given id.  If that fails it returns the status to respond
+// with, invalid entries are reported with a ValidationError.
+func applyRequestPatch(repo Repository, req *http.Request, id string) (*Entry, int, error) {
+	typ, err := patchType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+
+	patch, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("could not read patch: %s", err)
+	}
+
+	var patchErr, invalidErr error
+	entry, err := repo.Modify(req.Context(), id, func(entry *Entry) error {
+		if !ifMatch(req, entry.ETag()) {
+			return ErrConflict
+		}
+		patchErr = ApplyPatch(entry, typ, patch)
+		if patchErr != nil {
+			return patchErr
+		}
+		invalidErr = ValidateEntry(entry)
+		return invalidErr
+	})
+	switch {
+	case err == ErrConflict:
+		return nil, http.StatusPreconditionFailed, fmt.Errorf("entry %q has been changed", id)
+	case invalidErr != nil:
+		return nil, http.StatusUnprocessableEntity, invalidErr
+	case patchErr != nil:
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("could not apply patch: %s", patchErr)
+	case err != nil:
+		return nil, http.StatusInternalServerError, fmt.Errorf("could not patch entry: %s", err)
+	case entry == nil:
+		return nil, http.StatusNotFound, fmt.Errorf("no entry with id %q", id)
+	}
+	return entry, http.StatusOK, nil
+}
+
+// respondStoredEntry responds with the entry as it is stored now, for
+// clients that want to see the result of their change.
+func respondStoredEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string, status int) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil || entry == nil {
+		log.Printf("Could not get stored entry %q: %s", id, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = entry.RenderJSON(buf)
+	if err != nil {
+		log.Printf("Could not render entry: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", entry.ETag())
+	w.WriteHeader(status)
+	io.Copy(w, buf)
+}
+
+// isJSON reports whether the request body is JSON.
+func isJSON(req *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// resultFormat returns the format of query results the client asked for,
+// either in the `format` parameter or the Accept header: "html", "json"
+// or "csv".
+func resultFormat(req *http.Request) string {
+	switch format := req.URL.Query().Get("format"); format {
+	case "json", "csv":
+		return format
+	case "":
+	default:
+		return "html"
+	}
+
+	accept := req.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json"):
+		return "json"
+	case strings.Contains(accept, "text/csv"):
+		return "csv"
+	default:
+		return "html"
+	}
+}
+
+// wantsJSON reports whether the client would like a JSON response instead
+// of being redirected to the html page.
+func wantsJSON(req *http.Request) bool {
+	return isJSON(req) || strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// FromRequest parses an entry from either a JSON body or form values.
+func FromRequest(req *http.Request) (*Entry, error) {
+	if isJSON(req) {
+		return FromJSON(req)
+	}
+	return FromPostForm(req)
+}
+
+// FromJSON parses an entry from a JSON body shaped like Entry.
+func FromJSON(req *http.Request) (*Entry, error) {
+	var entry Entry
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+
+	entry.ID = ""
+	entry.DeletedAt = nil
+
+	return &entry, nil
+}
+
+// deleteEntry moves an entry to the trash.
+func deleteEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil {
+		log.Printf("Could not get entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not get entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if entry == nil || entry.DeletedAt != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if !ifMatch(req, entry.ETag()) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+
+	err = repo.Delete(req.Context(), entry.ID)
+	if err != nil {
+		log.Printf("Could not delete entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not delete entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// the html form can't send DELETE, so it POSTs and expects to be
+	// redirected somewhere useful afterwards
+	if req.Method == "POST" {
+		w.Header().Set("Location", "/")
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func restoreEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil {
+		log.Printf("Could not get entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not get entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if entry == nil || entry.DeletedAt == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	err = repo.Restore(req.Context(), entry.ID)
+	if err != nil {
+		log.Printf("Could not restore entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not restore entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Location", "/"+entry.ID)
 	w.WriteHeader(http.StatusFound)
 }
 
+// purgeEntry removes an entry from the trash for good.
+func purgeEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil {
+		log.Printf("Could not get entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not get entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if entry == nil || entry.DeletedAt == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	err = repo.Purge(req.Context(), entry.ID)
+	if err != nil {
+		log.Printf("Could not purge entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not purge entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/trash")
+	w.WriteHeader(http.StatusFound)
+}
+
+func renderHistory(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil {
+		log.Printf("Could not get entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not get entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if entry == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	revisions, err := repo.History(req.Context(), entry.ID)
+	if err != nil {
+		log.Printf("Could not get history: %s", err)
+		http.Error(w, fmt.Sprintf("Could not get history: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if strings.Contains(req.Header.Get("Accept"), "html") {
+		err = RenderHistoryHTML(buf, *entry, revisions)
+	} else {
+		enc := json.NewEncoder(buf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(revisions)
+	}
+	if err != nil {
+		log.Printf("Could not render history: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	io.Copy(w, buf)
+}
+
+func revertEntry(repo Repository, w http.ResponseWriter, req *http.Request, id string) {
+	entry, err := repo.Get(req.Context(), id)
+	if err != nil {
+		log.Printf("Could not get entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not get entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if entry == nil || entry.DeletedAt != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	revision, err := strconv.ParseInt(req.FormValue("revision"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid revision %q: %s", req.FormValue("revision"), err), http.StatusBadRequest)
+		return
+	}
+
+	// the history page sends the version it was rendered with, scripts
+	// can send If-Match instead
+	version := entry.Version
+	if req.FormValue("version") != "" {
+		version, err = strconv.ParseInt(req.FormValue("version"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid version %q: %s", req.FormValue("version"), err), http.StatusBadRequest)
+			return
+		}
+	}
+	if !ifMatch(req, entry.ETag()) || version != entry.Version {
+		http.Error(w, "The entry has been changed in the meantime, reload and try again.", http.StatusPreconditionFailed)
+		return
+	}
+
+	err = repo.Revert(req.Context(), entry.ID, revision, entry.Version)
+	if err == ErrNotFound || err == ErrNoRevision {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err == ErrConflict {
+		http.Error(w, "The entry has been changed in the meantime, reload and try again.", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Could not revert entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not revert entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/"+entry.ID)
+	w.WriteHeader(http.StatusFound)
+}
+
+// FromPostForm parses an entry from form values.  The date is left empty
+// if the form doesn't contain one.
 func FromPostForm(req *http.Request) (*Entry, error) {
 	err := req.ParseForm()
 	if err != nil {
 		return nil, fmt.Errorf("invalid form: %s", err)
 	}
 
-	date := time.Now().UTC().Round(time.Millisecond)
+	var date time.Time
 	if len(req.PostForm.Get("date")) > 0 {
-		date, err = time.Parse(time.RFC3339, req.PostForm.Get("date"))
+		date, err = parseFormDate(req.PostForm.Get("date"))
 		if err != nil {
 			return nil, fmt.Errorf("value of 'date' (%q) is not a valid date: %s",
 				req.PostForm.Get("date"), err)
@@ -279,28 +1025,36 @@ func FromPostForm(req *http.Request) (*Entry, error) {
 		entry.Value = v
 	}
 
+	if version := req.PostForm.Get("version"); version != "" {
+		v, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value %q of 'version' is not a number: %s", version, err)
+		}
+		entry.Version = v
+	}
+
+	// declared fields are parsed according to their type, everything
+	// else is kept as text
+	td := entryTypes.Get(entry.Type)
 	additionalData := map[string]interface{}{}
 	for key, vals := range req.PostForm {
 		// ignore "standard" fields
 		switch key {
-		case "date", "type", "note", "value":
+		case "date", "type", "note", "value", "version":
+			continue
+		case "tags":
+			entry.Tags = parseTags(vals...)
 			continue
 		}
 
-		parsedVals := []interface{}{}
-		for _, val := range vals {
-			var parsedVal interface{}
-			err := json.Unmarshal([]byte(val), &parsedVal)
-			if err != nil {
-				parsedVals = append(parsedVals, val)
-			} else {
-				parsedVals = append(parsedVals, parsedVal)
-			}
+		if val, ok := td.ParseField(key, vals); ok {
+			additionalData[key] = val
 		}
-		if len(parsedVals) == 1 {
-			additionalData[key] = parsedVals[0]
-		} else {
-			additionalData[key] = parsedVals
+	}
+	for _, field := range td.Fields {
+		// unchecked checkboxes are not sent at all
+		if _, ok := additionalData[field.Name]; !ok && field.Type == "boolean" {
+			additionalData[field.Name] = false
 		}
 	}
 	if len(additionalData) >= 1 {
@@ -309,3 +1063,15 @@ func FromPostForm(req *http.Request) (*Entry, error) {
 
 	return entry, nil
 }
+
+// parseFormDate parses RFC 3339 dates as well as the values of
+// datetime-local inputs, which are taken to be in UTC.
+func parseFormDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ETag returns the entity tag of the entry, which changes whenever the
+// entry is updated.
+func (e Entry) ETag() string {
+	return fmt.Sprintf(`"%d"`, e.Version)
+}
+
+// matchesETag reports whether etag is in the list of entity tags in an
+// If-Match or If-None-Match header.  Weak tags are compared like strong
+// ones, because all representations of an entry change together.
+func matchesETag(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch reports whether the request may change a resource with the
+// given etag.
+func ifMatch(req *http.Request, etag string) bool {
+	header := req.Header.Get("If-Match")
+	return header == "" || matchesETag(header, etag)
+}
+
+// writeWithETag writes buf, or just 304 Not Modified if the client
+// already has the version with the given etag.  If etag is empty, it is
+// derived from the content.
+func writeWithETag(w http.ResponseWriter, req *http.Request, etag string, buf *bytes.Buffer) {
+	if etag == "" {
+		etag = `"` + contentHash(buf.Bytes()) + `"`
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+
+	header := req.Header.Get("If-None-Match")
+	if header != "" && matchesETag(header, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	io.Copy(w, buf)
+}
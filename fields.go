@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldTypes are the types of declared fields.  Dates are stored as
+// strings like "2019-10-01", durations as a number of seconds and lists
+// as lists of strings.
+var fieldTypes = []string{"string", "number", "boolean", "date", "duration", "enum", "list"}
+
+const fieldDateLayout = "2006-01-02"
+
+// Parse converts the values of the field in a form or on the command line
+// to its type.  Values that can't be converted are kept as text, so that
+// they are reported by Validate and can be shown again.  ok is false if
+// there is no value to store.
+func (fd *FieldDefinition) Parse(vals []string) (val interface{}, ok bool) {
+	if fd.Type == "list" {
+		items := []interface{}{}
+		for _, val := range vals {
+			for _, item := range strings.Split(val, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+		return items, len(items) > 0
+	}
+
+	if fd.Type == "" && len(vals) > 1 {
+		items := make([]interface{}, len(vals))
+		for i, val := range vals {
+			items[i] = val
+		}
+		return items, true
+	}
+
+	if len(vals) == 0 {
+		return nil, false
+	}
+	raw := strings.TrimSpace(vals[0])
+	if raw == "" {
+		return nil, false
+	}
+
+	switch fd.Type {
+	case "number":
+		if f, err := parseNumber(raw); err == nil {
+			return f, true
+		}
+	case "boolean":
+		switch strings.ToLower(raw) {
+		case "true", "on", "yes", "1":
+			return true, true
+		case "false", "off", "no", "0":
+			return false, true
+		}
+	case "date":
+		if d, err := time.Parse(fieldDateLayout, raw); err == nil {
+			return d.Format(fieldDateLayout), true
+		}
+	case "duration":
+		if d, err := parseFieldDuration(raw); err == nil {
+			return d.Seconds(), true
+		}
+	default:
+		// text is kept as it was entered
+		return vals[0], true
+	}
+	return raw, true
+}
+
+// parseNumber parses finite numbers.  strconv.ParseFloat also accepts
+// "nan" and "inf", which can't be stored as JSON.
+func parseNumber(raw string) (float64, error) {
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, err
+	}
+	if !isFinite(f) {
+		return 0, fmt.Errorf("%q is not a finite number", raw)
+	}
+	return f, nil
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// parseFieldDuration parses durations like 1h30m, 1:30 (hours and
+// minutes) or 90 (minutes).
+func parseFieldDuration(raw string) (time.Duration, error) {
+	if d, err := time.ParseDuration(raw); err == nil {
+		return d, nil
+	}
+	if minutes, err := parseNumber(raw); err == nil {
+		return time.Duration(minutes * float64(time.Minute)), nil
+	}
+	if idx := strings.Index(raw, ":"); idx > 0 {
+		hours, err1 := strconv.Atoi(raw[:idx])
+		minutes, err2 := strconv.Atoi(raw[idx+1:])
+		if err1 == nil && err2 == nil && minutes < 60 {
+			return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a duration like 1h30m", raw)
+}
+
+// formatFieldDuration formats a number of seconds like 1h30m.
+func formatFieldDuration(seconds float64) string {
+	s := (time.Duration(seconds) * time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// formField is a declared field with its value in an entry, as shown in
+// the forms.
+type formField struct {
+	FieldDefinition
+	Value interface{}
+}
+
+// InputType returns the type attribute of the input for the field.
+func (ff formField) InputType() string {
+	switch ff.Type {
+	case "number":
+		return "number"
+	case "date":
+		return "date"
+	default:
+		return "text"
+	}
+}
+
+// Placeholder shows the expected format of fields that need one.
+func (ff formField) Placeholder() string {
+	switch ff.Type {
+	case "duration":
+		return "1h30m"
+	case "list":
+		return "one, two, three"
+	default:
+		return ""
+	}
+}
+
+// Text returns the value as it is shown in a text input.
+func (ff formField) Text() string {
+	switch val := ff.Value.(type) {
+	case nil:
+		return ""
+	case float64:
+		if ff.Type == "duration" {
+			return formatFieldDuration(val)
+		}
+		return formatNumber(val)
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ", ")
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// Values returns the values of untyped fields, which get an input each.
+func (ff formField) Values() []interface{} {
+	if list, ok := ff.Value.([]interface{}); ok {
+		return list
+	}
+	if ff.Value == nil {
+		return nil
+	}
+	return []interface{}{ff.Value}
+}
+
+// Has reports whether option is the value or one of them.
+func (ff formField) Has(option string) bool {
+	for _, val := range ff.Values() {
+		if val == option {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTrue reports whether the value of a boolean field is true.
+func (ff formField) IsTrue() bool {
+	return ff.Value == true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldParse(t *testing.T) {
+	tests := []struct {
+		typ      string
+		vals     []string
+		expected interface{}
+	}{
+		{"", []string{"123"}, "123"},
+		{"", []string{"a", "b"}, []interface{}{"a", "b"}},
+		{"string", []string{" true "}, " true "},
+		{"number", []string{"2.5"}, 2.5},
+		{"number", []string{"two"}, "two"},
+		{"number", []string{"NaN"}, "NaN"},
+		{"number", []string{"-inf"}, "-inf"},
+		{"boolean", []string{"on"}, true},
+		{"boolean", []string{"false"}, false},
+		{"date", []string{"2019-10-01"}, "2019-10-01"},
+		{"date", []string{"yesterday"}, "yesterday"},
+		{"duration", []string{"1h30m"}, 5400.0},
+		{"duration", []string{"1:30"}, 5400.0},
+		{"duration", []string{"45"}, 2700.0},
+		{"duration", []string{"infinity"}, "infinity"},
+		{"enum", []string{"ok"}, "ok"},
+		{"list", []string{"bed, couch", "floor"}, []interface{}{"bed", "couch", "floor"}},
+		{"list", []string{" , "}, nil},
+		{"number", []string{""}, nil},
+	}
+
+	for _, test := range tests {
+		field := FieldDefinition{Name: "test", Type: test.typ}
+		val, ok := field.Parse(test.vals)
+		if ok != (test.expected != nil) || (ok && !reflect.DeepEqual(val, test.expected)) {
+			t.Errorf("expected %#v for %s %q, but got %#v (%v)", test.expected, test.typ, test.vals, val, ok)
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+	td := &TypeDefinition{Name: "test", Fields: []FieldDefinition{{Name: "cups", Type: "number"}}}
+
+	val, ok := td.ParseField("cups", []string{"2"})
+	if !ok || val != 2.0 {
+		t.Errorf("expected declared fields to be converted, but got %#v", val)
+	}
+	val, ok = td.ParseField("milk", []string{"2"})
+	if !ok || val != "2" {
+		t.Errorf("expected undeclared fields to be text, but got %#v", val)
+	}
+}
+
+func TestFormFieldText(t *testing.T) {
+	tests := []struct {
+		field    formField
+		expected string
+	}{
+		{formField{FieldDefinition{Type: "duration"}, 5400.0}, "1h30m"},
+		{formField{FieldDefinition{Type: "duration"}, 7200.0}, "2h"},
+		{formField{FieldDefinition{Type: "duration"}, 90.0}, "1m30s"},
+		{formField{FieldDefinition{Type: "number"}, 2.5}, "2.5"},
+		{formField{FieldDefinition{Type: "list"}, []interface{}{"bed", "couch"}}, "bed, couch"},
+		{formField{FieldDefinition{Type: "string"}, nil}, ""},
+	}
+
+	for _, test := range tests {
+		text := test.field.Text()
+		if text != test.expected {
+			t.Errorf("expected %q for %#v, but got %q", test.expected, test.field.Value, text)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter is a structured query for entries, which repositories translate
+// to their own query language.  Zero values don't restrict the result.
+type Filter struct {
+	Types        []string
+	Tags         []string
+	From         time.Time
+	To           time.Time
+	MinValue     *float64
+	MaxValue     *float64
+	NoteContains string
+	Data         []DataPredicate
+
+	IncludeDeleted bool
+
+	Order order
+	Limit int
+}
+
+// DataPredicate restricts the value of a key in the additional data of
+// an entry.
+type DataPredicate struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+// dataOps are the supported operators of data predicates, longer ones
+// first so that parsing finds "<=" before "<".
+var dataOps = []string{"!=", "<=", ">=", "=", "<", ">", "~"}
+
+// parseDataPredicate parses predicates like "location=home", "cups>=2",
+// "note~tired" or just "location", which matches if the key exists.
+func parseDataPredicate(s string) (DataPredicate, error) {
+	idx := strings.IndexAny(s, "!=<>~")
+	if idx == -1 {
+		return DataPredicate{Key: s, Op: "exists"}, nil
+	}
+	if idx == 0 {
+		return DataPredicate{}, fmt.Errorf("data predicate %q has no key", s)
+	}
+
+	for _, op := range dataOps {
+		if strings.HasPrefix(s[idx:], op) {
+			return DataPredicate{Key: s[:idx], Op: op, Value: s[idx+len(op):]}, nil
+		}
+	}
+	return DataPredicate{}, fmt.Errorf("data predicate %q has an invalid operator", s)
+}
+
+func (p DataPredicate) String() string {
+	if p.Op == "exists" {
+		return p.Key
+	}
+	return p.Key + p.Op + p.Value
+}
+
+// Matches reports whether the additional data satisfies the predicate.
+// Values are compared as numbers if both sides are numbers and as strings
+// otherwise, lists match if any of their elements does.
+func (p DataPredicate) Matches(data map[string]interface{}) bool {
+	val, ok := data[p.Key]
+	if !ok {
+		return p.Op == "!="
+	}
+	if p.Op == "exists" {
+		return true
+	}
+
+	if list, ok := val.([]interface{}); ok {
+		for _, elem := range list {
+			if p.matchesValue(elem) {
+				return p.Op != "!="
+			}
+		}
+		return p.Op == "!="
+	}
+	return p.matchesValue(val) != (p.Op == "!=")
+}
+
+// matchesValue compares a single value, "!=" is treated like "=" and
+// negated by the caller.
+func (p DataPredicate) matchesValue(val interface{}) bool {
+	var str string
+	switch v := val.(type) {
+	case string:
+		str = v
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		str = string(buf)
+	}
+
+	if p.Op == "~" {
+		return strings.Contains(strings.ToLower(str), strings.ToLower(p.Value))
+	}
+
+	cmp := strings.Compare(str, p.Value)
+	num, err1 := strconv.ParseFloat(str, 64)
+	expected, err2 := strconv.ParseFloat(p.Value, 64)
+	if err1 == nil && err2 == nil {
+		switch {
+		case num < expected:
+			cmp = -1
+		case num > expected:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+
+	switch p.Op {
+	case "=", "!=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	default:
+		return false
+	}
+}
+
+// dataMatchesSQL is registered as the data_matches function in SQLite so
+// that data predicates can be evaluated as part of a query.
+func dataMatchesSQL(rawData interface{}, key, op, value string) (bool, error) {
+	var buf []byte
+	switch d := rawData.(type) {
+	case []byte:
+		buf = d
+	case string:
+		buf = []byte(d)
+	}
+	if len(buf) == 0 {
+		buf = []byte("null")
+	}
+
+	var data map[string]interface{}
+	err := json.Unmarshal(buf, &data)
+	if err != nil {
+		return false, fmt.Errorf("invalid additional data: %s", err)
+	}
+
+	return DataPredicate{Key: key, Op: op, Value: value}.Matches(data), nil
+}
+
+// parseFilter reads a Filter from the `type`, `tag`, `from`, `to`, `min`,
+// `max`, `note`, `data`, `deleted`, `order` and `limit` parameters.
+func parseFilter(params url.Values) (Filter, error) {
+	f := Filter{
+		Order:        Descending,
+		Limit:        defaultListLimit,
+		NoteContains: params.Get("note"),
+	}
+
+	for _, types := range params["type"] {
+		for _, typ := range strings.Split(types, ",") {
+			if typ = strings.TrimSpace(typ); typ != "" {
+				f.Types = append(f.Types, typ)
+			}
+		}
+	}
+	f.Tags = parseTags(params["tag"]...)
+
+	var err error
+	f.From, err = parseDateParam(params.Get("from"), time.Time{})
+	if err != nil {
+		return f, fmt.Errorf("invalid from: %s", err)
+	}
+	f.To, err = parseEndDateParam(params.Get("to"))
+	if err != nil {
+		return f, fmt.Errorf("invalid to: %s", err)
+	}
+
+	for _, param := range []string{"min", "max"} {
+		if params.Get(param) == "" {
+			continue
+		}
+		val, err := strconv.ParseFloat(params.Get(param), 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s %q: not a number", param, params.Get(param))
+		}
+		if param == "min" {
+			f.MinValue = &val
+		} else {
+			f.MaxValue = &val
+		}
+	}
+
+	for _, param := range params["data"] {
+		if param == "" {
+			continue
+		}
+		predicate, err := parseDataPredicate(param)
+		if err != nil {
+			return f, err
+		}
+		f.Data = append(f.Data, predicate)
+	}
+
+	f.IncludeDeleted = params.Get("deleted") != ""
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		f.Order = Ascending
+	default:
+		return f, fmt.Errorf("invalid order %q, must be asc or desc", params.Get("order"))
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		f.Limit, err = strconv.Atoi(limit)
+		if err != nil || f.Limit < 1 || f.Limit > maxListLimit {
+			return f, fmt.Errorf("invalid limit %q, must be between 1 and %d", limit, maxListLimit)
+		}
+	}
+
+	return f, nil
+}
+
+// isFilterEmpty reports whether none of the filter parameters are in params.
+func isFilterEmpty(params url.Values) bool {
+	for _, param := range []string{"type", "tag", "from", "to", "min", "max", "note", "data", "deleted", "order", "limit"} {
+		if params.Get(param) != "" {
+			return false
+		}
+	}
+	return true
+}
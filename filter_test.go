@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseDataPredicate(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected DataPredicate
+	}{
+		{"location", DataPredicate{Key: "location", Op: "exists"}},
+		{"location=home", DataPredicate{Key: "location", Op: "=", Value: "home"}},
+		{"cups>=2", DataPredicate{Key: "cups", Op: ">=", Value: "2"}},
+		{"milk!=oat", DataPredicate{Key: "milk", Op: "!=", Value: "oat"}},
+		{"mood~tired", DataPredicate{Key: "mood", Op: "~", Value: "tired"}},
+	}
+
+	for _, test := range tests {
+		predicate, err := parseDataPredicate(test.in)
+		if err != nil {
+			t.Errorf("could not parse %q: %s", test.in, err)
+			continue
+		}
+		if predicate != test.expected {
+			t.Errorf("expected %#v for %q, but got %#v", test.expected, test.in, predicate)
+		}
+	}
+
+	_, err := parseDataPredicate("=home")
+	if err == nil {
+		t.Error("predicate without key should be invalid")
+	}
+}
+
+func TestIsFilterEmpty(t *testing.T) {
+	tests := []struct {
+		query string
+		empty bool
+	}{
+		{"", true},
+		{"query=SELECT+1", true},
+		{"type=", true},
+		{"type=coffee", false},
+		{"deleted=1", false},
+		{"order=asc", false},
+		{"limit=10", false},
+	}
+
+	for _, test := range tests {
+		params, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatalf("invalid query %q: %s", test.query, err)
+		}
+		if empty := isFilterEmpty(params); empty != test.empty {
+			t.Errorf("expected %t for %q, but got %t", test.empty, test.query, empty)
+		}
+	}
+}
+
+func TestDataPredicateMatches(t *testing.T) {
+	data := map[string]interface{}{
+		"location": "home",
+		"cups":     2.0,
+		"tags":     []interface{}{"work", "tired"},
+	}
+
+	tests := []struct {
+		predicate string
+		matches   bool
+	}{
+		{"location", true},
+		{"weather", false},
+		{"location=home", true},
+		{"location!=home", false},
+		{"weather!=rainy", true},
+		{"cups>=2", true},
+		{"cups>10", false},
+		{"cups<10", true},
+		{"tags=tired", true},
+		{"tags!=tired", false},
+		{"location~HO", true},
+	}
+
+	for _, test := range tests {
+		predicate, err := parseDataPredicate(test.predicate)
+		if err != nil {
+			t.Fatalf("could not parse %q: %s", test.predicate, err)
+		}
+		if predicate.Matches(data) != test.matches {
+			t.Errorf("expected %q to match: %v", test.predicate, test.matches)
+		}
+	}
+}
@@ -3,6 +3,8 @@
 ARGS="${ARGS:-}"
 RUN_DIR="${RUN_DIR:-/srv/daily}"
 BINARY_PATH="${BINARY_PATH:-${RUN_DIR}/daily}"
+DB_PATH="${DB_PATH:-${RUN_DIR}/test.db}"
+TYPES_DIR="${TYPES_DIR:-${RUN_DIR}/types}"
 RUN_USER="${RUN_USER:-daily}"
 RUN_GROUP="${RUN_GROUP:-daily}"
 
@@ -13,9 +15,8 @@ Description=daily - track data about points in time
 [Service]
 User=$RUN_USER
 Group=$RUN_GROUP
-ExecStart=$BINARY_PATH $ARGS
+ExecStart=$BINARY_PATH -db $DB_PATH -types-dir $TYPES_DIR $ARGS
 ReadWritePaths=${RUN_DIR}
-WorkingDirectory=$RUN_DIR
 
 [Install]
 WantedBy=multi-user.target
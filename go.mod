@@ -1,6 +1,9 @@
 module github.com/heyLu/daily
 
+go 1.16
+
 require (
 	github.com/gorilla/mux v1.7.3
 	github.com/mattn/go-sqlite3 v1.10.1-0.20190924013945-4396a38886da
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateEntryJSON(t *testing.T) {
+	repo := newTestRepository(t)
+
+	var testCases = []struct {
+		contentType string
+		accept      string
+		body        string
+		status      int
+		json        bool
+	}{
+		{"application/json", "", `{"type":"test","note":"json body"}`, http.StatusCreated, true},
+		{"application/json; charset=utf-8", "", `{"type":"test","value":3}`, http.StatusCreated, true},
+		{"application/x-www-form-urlencoded", "application/json", url.Values{"type": {"test"}, "note": {"form body"}}.Encode(), http.StatusCreated, true},
+		{"application/x-www-form-urlencoded", "", url.Values{"type": {"test"}}.Encode(), http.StatusFound, false},
+		{"application/json", "", `{"type":"test","color":"blue"}`, http.StatusBadRequest, false},
+		{"application/json", "", `{"type":"test"`, http.StatusBadRequest, false},
+		{"application/json", "", `{"type":""}`, http.StatusUnprocessableEntity, false},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("POST", "/new", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rec := httptest.NewRecorder()
+		createEntry(repo, rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, but got %d: %s", tc.body, tc.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if tc.status >= 400 {
+			continue
+		}
+
+		location := rec.Header().Get("Location")
+		if !strings.HasPrefix(location, "/") || len(location) < 2 {
+			t.Errorf("%s: expected the location of the entry, but got %q", tc.body, location)
+			continue
+		}
+		stored, err := repo.Get(context.Background(), location[1:])
+		if err != nil || stored == nil {
+			t.Errorf("%s: could not get created entry: %v", tc.body, err)
+			continue
+		}
+
+		if !tc.json {
+			if ct := rec.Header().Get("Content-Type"); ct == "application/json" {
+				t.Errorf("%s: expected a redirect for html clients, but got json", tc.body)
+			}
+			continue
+		}
+
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: expected a json response, but got %q", tc.body, ct)
+		}
+		if etag := rec.Header().Get("ETag"); etag != stored.ETag() {
+			t.Errorf("%s: expected ETag %s, but got %q", tc.body, stored.ETag(), etag)
+		}
+		var created Entry
+		err = json.Unmarshal(rec.Body.Bytes(), &created)
+		if err != nil {
+			t.Errorf("%s: invalid json response: %s", tc.body, err)
+			continue
+		}
+		if created.ID != stored.ID || created.Type != "test" || created.Date.IsZero() {
+			t.Errorf("%s: unexpected entry in response: %#v", tc.body, created)
+		}
+	}
+}
+
+func TestEditEntryJSON(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	date := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	id, err := repo.Create(ctx, &Entry{Date: date, Type: "test", Note: "before"})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	edit := func(body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for key, val := range headers {
+			req.Header.Set(key, val)
+		}
+		rec := httptest.NewRecorder()
+		editEntry(repo, rec, req, id)
+		return rec
+	}
+
+	rec := edit(`{"note":"after","value":2}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, but got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a json response, but got %q", ct)
+	}
+
+	var edited Entry
+	err = json.Unmarshal(rec.Body.Bytes(), &edited)
+	if err != nil {
+		t.Fatalf("invalid json response: %s", err)
+	}
+	if edited.Note != "after" || edited.Value != 2 || edited.Version != 2 {
+		t.Errorf("unexpected entry in response: %#v", edited)
+	}
+	if edited.Type != "test" || !edited.Date.Equal(date) {
+		t.Errorf("expected date and type to be kept, but got %s and %q", edited.Date, edited.Type)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"2"` {
+		t.Errorf(`expected ETag "2", but got %q`, etag)
+	}
+
+	rec = edit(`{"note":"stale"}`, map[string]string{"If-Match": `"1"`})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for an outdated If-Match, but got %d", rec.Code)
+	}
+
+	rec = edit(`{"note":"unknown","colour":"red"}`, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown fields, but got %d", rec.Code)
+	}
+
+	stored, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if stored.Note != "after" || stored.Version != 2 {
+		t.Errorf("rejected edits should not change the entry, but got %#v", stored)
+	}
+
+	req := httptest.NewRequest("POST", "/doesnotexist", strings.NewReader(`{"note":"missing"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	editEntry(repo, rec, req, "doesnotexist")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing entry, but got %d", rec.Code)
+	}
+}
+
+func TestPatchEntry(t *testing.T) {
+	repo := newTestRepository(t)
+
+	id, err := repo.Create(context.Background(), &Entry{Date: time.Now(), Type: "mood", Value: 0.5})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	var testCases = []struct {
+		contentType string
+		ifMatch     string
+		body        string
+		status      int
+		message     string
+	}{
+		{"application/merge-patch+json", `"2"`, `{"value":0.25}`, http.StatusPreconditionFailed, "has been changed"},
+		{"application/merge-patch+json", "", `{"value":2}`, http.StatusUnprocessableEntity, "Invalid entry: value must be at most 1"},
+		{"application/json-patch+json", "", `[{"op":"remove","path":"/nothing"}]`, http.StatusUnprocessableEntity, "could not apply patch"},
+		{"text/plain", "", `value=0.25`, http.StatusUnsupportedMediaType, "unsupported patch type"},
+		{"application/merge-patch+json", `"1"`, `{"value":0.25}`, http.StatusOK, `"value": 0.25`},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("PATCH", "/"+id, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		patchEntry(repo, rec, req, id)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, but got %d: %s", tc.body, tc.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), tc.message) {
+			t.Errorf("%s: expected %q in the response, but got %q", tc.body, tc.message, rec.Body.String())
+		}
+	}
+}
+
+func TestParseFormDate(t *testing.T) {
+	var testCases = []struct {
+		value    string
+		expected time.Time
+	}{
+		{"2020-03-02T08:15", time.Date(2020, 3, 2, 8, 15, 0, 0, time.UTC)},
+		{"2020-03-02T08:15:30", time.Date(2020, 3, 2, 8, 15, 30, 0, time.UTC)},
+		{"2020-03-02T08:15:30Z", time.Date(2020, 3, 2, 8, 15, 30, 0, time.UTC)},
+		{"2020-03-02T08:15:30+02:00", time.Date(2020, 3, 2, 6, 15, 30, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		date, err := parseFormDate(tc.value)
+		if err != nil {
+			t.Errorf("%s: %s", tc.value, err)
+			continue
+		}
+		if !date.Equal(tc.expected) {
+			t.Errorf("%s: expected %s, but got %s", tc.value, tc.expected, date)
+		}
+	}
+
+	for _, value := range []string{"", "2020-03-02", "08:15", "2020-03-02 08:15"} {
+		_, err := parseFormDate(value)
+		if err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestEditEntryForm(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	date := time.Date(2020, 3, 1, 12, 0, 0, 123000000, time.UTC)
+	id, err := repo.Create(ctx, &Entry{Date: date, Type: "kaffee", Note: "before"})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	edit := func(form url.Values) {
+		t.Helper()
+
+		req := httptest.NewRequest("POST", "/"+id, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		editEntry(repo, rec, req, id)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("expected a redirect, but got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// the form shows the date without milliseconds, sending it back
+	// unchanged keeps the stored date
+	edit(url.Values{"date": {"2020-03-01T12:00:00"}, "type": {"kaffee"}, "note": {"unchanged date"}, "version": {"1"}})
+	stored, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if !stored.Date.Equal(date) || stored.Note != "unchanged date" {
+		t.Errorf("expected the stored date %s to be kept, but got %s", date, stored.Date)
+	}
+
+	// datetime-local inputs have no time zone, they are taken to be in UTC
+	edit(url.Values{"date": {"2020-03-02T08:15"}, "type": {"coffee"}, "version": {"2"}})
+	stored, err = repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	expected := time.Date(2020, 3, 2, 8, 15, 0, 0, time.UTC)
+	if !stored.Date.Equal(expected) {
+		t.Errorf("expected date %s, but got %s", expected, stored.Date)
+	}
+	if stored.Type != "coffee" {
+		t.Errorf("expected type coffee, but got %q", stored.Type)
+	}
+}
+
+func TestRenameTypeInvalidName(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	id, err := repo.Create(ctx, &Entry{Date: time.Now(), Type: "kaffee"})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	for _, to := range []string{"", "kaffee", "a/b", `a\b`, ".hidden"} {
+		form := url.Values{"to": {to}}
+		req := httptest.NewRequest("POST", "/types/kaffee/rename", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		renameType(repo, rec, req, "kaffee")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, but got %d: %s", to, rec.Code, rec.Body.String())
+		}
+	}
+
+	entry, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if entry.Type != "kaffee" || entry.Version != 1 {
+		t.Errorf("invalid renames should not change entries, but got %#v", entry)
+	}
+}
+
+func TestRenameTypeKeepsEntriesOnFailure(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	coffee, err := repo.Create(ctx, &Entry{Date: time.Now(), Type: "coffee", Value: 1})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+	kaffee, err := repo.Create(ctx, &Entry{Date: time.Now(), Type: "kaffee", Value: 5})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	rename := func(name string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/types/"+name+"/rename", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		renameType(repo, rec, req, name)
+		return rec
+	}
+
+	// there is no -types-dir to write the definition to
+	rec := rename("coffee", url.Values{"to": {"java"}})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 if the definition can't be saved, but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// mood values are at most 1
+	rec = rename("kaffee", url.Values{"to": {"mood"}, "merge": {"1"}})
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), kaffee) {
+		t.Errorf("expected 422 with the invalid entry, but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for id, typ := range map[string]string{coffee: "coffee", kaffee: "kaffee"} {
+		entry, err := repo.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("could not get entry: %s", err)
+		}
+		if entry.Type != typ || entry.Version != 1 {
+			t.Errorf("failed renames should not change entries, but got %#v", entry)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "daily-types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(types *typeRegistry) { entryTypes = types }(entryTypes)
+	entryTypes = newTypeRegistry(mustSub(embeddedTypes, "types"))
+	err = entryTypes.Load(dir)
+	if err != nil {
+		t.Fatalf("could not load types: %s", err)
+	}
+
+	// kaffee is used already, so the definition written for it is removed again
+	rec = rename("coffee", url.Values{"to": {"kaffee"}})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409, but got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, ok := entryTypes.Lookup("kaffee"); ok {
+		t.Errorf("expected the definition of kaffee to be removed again")
+	}
+}
+
+func TestRenderSearchStatus(t *testing.T) {
+	repo := newTestRepository(t)
+
+	search := func(q string) int {
+		req := httptest.NewRequest("GET", "/search?"+url.Values{"q": {q}}.Encode(), nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		renderSearch(repo, rec, req)
+		return rec.Code
+	}
+
+	if status := search("tired"); status != http.StatusOK {
+		t.Errorf("expected 200, but got %d", status)
+	}
+	if status := search(`"unbalanced`); status != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid search, but got %d", status)
+	}
+
+	_, err := repo.(*repository).db.Exec("ALTER TABLE entry_tags RENAME TO entry_tags_old")
+	if err != nil {
+		t.Fatalf("could not rename table: %s", err)
+	}
+	if status := search("tired"); status != http.StatusInternalServerError {
+		t.Errorf("expected 500 for a broken database, but got %d", status)
+	}
+}
+
+func TestRevertEntry(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	entry := &Entry{Date: time.Now(), Type: "mood", Value: 0.5, Note: "first"}
+	id, err := repo.Create(ctx, entry)
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+	entry.ID = id
+	entry.Version = 1
+	entry.Note = "second"
+	err = repo.Update(ctx, entry)
+	if err != nil {
+		t.Fatalf("could not update entry: %s", err)
+	}
+	revisions, err := repo.History(ctx, id)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("expected one revision, but got %v (%v)", revisions, err)
+	}
+	revision := strconv.FormatInt(revisions[0].ID, 10)
+
+	revert := func(form url.Values, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/"+id+"/revert", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		revertEntry(repo, rec, req, id)
+		return rec
+	}
+
+	var testCases = []struct {
+		form    url.Values
+		headers []string
+		status  int
+	}{
+		{url.Values{"revision": {revision + "0"}}, nil, http.StatusNotFound},
+		{url.Values{"revision": {revision}}, []string{"If-Match", `"1"`}, http.StatusPreconditionFailed},
+		{url.Values{"revision": {revision}, "version": {"1"}}, nil, http.StatusPreconditionFailed},
+		{url.Values{"revision": {revision}, "version": {"2"}}, []string{"If-Match", `"2"`}, http.StatusFound},
+	}
+	for _, tc := range testCases {
+		rec := revert(tc.form, tc.headers...)
+		if rec.Code != tc.status {
+			t.Errorf("%v %v: expected %d, but got %d: %s", tc.form, tc.headers, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	reverted, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if reverted.Note != "first" || reverted.Version != 3 {
+		t.Errorf("expected the entry to be reverted once, but got %#v", reverted)
+	}
+}
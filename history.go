@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Revision is a previous version of an entry, as it was before being
+// changed at RevisedAt.
+type Revision struct {
+	ID        int64     `json:"id"`
+	RevisedAt time.Time `json:"revised_at"`
+	Entry     Entry     `json:"entry"`
+}
+
+// Change describes how a single field of an entry changed.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Diff lists the fields that differ between the old and the new entry.
+func Diff(old, new Entry) []Change {
+	changes := []Change{}
+
+	if !old.Date.Equal(new.Date) {
+		changes = append(changes, Change{Field: "date", Old: old.Date.String(), New: new.Date.String()})
+	}
+	if old.Type != new.Type {
+		changes = append(changes, Change{Field: "type", Old: old.Type, New: new.Type})
+	}
+	if old.Note != new.Note {
+		changes = append(changes, Change{Field: "note", Old: old.Note, New: new.Note})
+	}
+	if old.Value != new.Value {
+		changes = append(changes, Change{Field: "value", Old: fmt.Sprint(old.Value), New: fmt.Sprint(new.Value)})
+	}
+	if oldTags, newTags := strings.Join(old.Tags, " "), strings.Join(new.Tags, " "); oldTags != newTags {
+		changes = append(changes, Change{Field: "tags", Old: oldTags, New: newTags})
+	}
+
+	keys := map[string]bool{}
+	for key := range old.Data {
+		keys[key] = true
+	}
+	for key := range new.Data {
+		keys[key] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	for _, key := range sortedKeys {
+		oldVal := dataString(old.Data, key)
+		newVal := dataString(new.Data, key)
+		if oldVal != newVal {
+			changes = append(changes, Change{Field: "data." + key, Old: oldVal, New: newVal})
+		}
+	}
+
+	return changes
+}
+
+func dataString(data map[string]interface{}, key string) string {
+	val, ok := data[key]
+	if !ok {
+		return ""
+	}
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(buf)
+}
+
+type historyStep struct {
+	Revision Revision
+	Changes  []Change
+}
+
+// RenderHistoryHTML renders the revisions of the entry, newest first,
+// each with the changes that were made to it afterwards.
+func RenderHistoryHTML(w io.Writer, current Entry, revisions []Revision) error {
+	steps := make([]historyStep, 0, len(revisions))
+	next := current
+	for i := len(revisions) - 1; i >= 0; i-- {
+		steps = append(steps, historyStep{
+			Revision: revisions[i],
+			Changes:  Diff(revisions[i].Entry, next),
+		})
+		next = revisions[i].Entry
+	}
+
+	return tmplHistory.Execute(w, map[string]interface{}{
+		"Title":      "History - daily",
+		"Entry":      current,
+		"Steps":      steps,
+		"Stylesheet": "entry.css",
+	})
+}
+
+var tmplHistory = template.Must(tmplEntryBase.New("history").Parse(`{{ template "html-start" . }}
+<a href="/{{ .Entry.ID }}">/{{ .Entry.ID }}</a>
+
+{{ template "entry" .Entry }}
+
+{{ $id := .Entry.ID }}
+{{ $version := .Entry.Version }}
+{{ range .Steps }}
+<section class="revision">
+	<h2>Changed {{ .Revision.RevisedAt }}</h2>
+
+	<table>
+		{{ range .Changes }}
+		<tr>
+			<th>{{ .Field }}</th>
+			<td><del>{{ .Old }}</del></td>
+			<td><ins>{{ .New }}</ins></td>
+		</tr>
+		{{ else }}
+		<tr><td>no changes</td></tr>
+		{{ end }}
+	</table>
+
+	<form method="POST" action="/{{ $id }}/revert">
+		<input type="hidden" name="revision" value="{{ .Revision.ID }}" />
+		<input type="hidden" name="version" value="{{ $version }}" />
+		<input type="submit" value="Revert to before this change" />
+	</form>
+</section>
+{{ else }}
+<p>This entry has never been changed.</p>
+{{ end }}
+{{ template "html-end" }}
+`))
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportSize limits the size of imports uploaded via http.
+const maxImportSize = 32 << 20
+
+// ImportOptions control how entries are read for an import.
+type ImportOptions struct {
+	// Format is the name of one of the importers, e.g. "csv" or "json".
+	Format string
+
+	// Mapping maps CSV columns to "date", "type", "note", "value",
+	// "tags", "data.<key>" or "-" to ignore them.  Columns that aren't mapped are
+	// used as they are if named like a field of entries, ignored if
+	// named "id" and stored in the additional data otherwise.
+	Mapping map[string]string
+
+	// Type is used for entries that don't have one.
+	Type string
+
+	DryRun bool
+}
+
+// ImportError describes why a row could not be imported.  Row is the
+// line in CSV files, and the position in JSON arrays, starting at 1.
+type ImportError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// ImportResult summarizes an import.  Nothing is imported if there are
+// errors.
+type ImportResult struct {
+	DryRun     bool          `json:"dry_run"`
+	Total      int           `json:"total"`
+	Imported   int           `json:"imported"`
+	Duplicates []int         `json:"duplicates"`
+	Errors     []ImportError `json:"errors"`
+}
+
+// parseMapping parses mappings like "Datum=date,Was=type,Ort=data.location".
+func parseMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid mapping %q, must be column=field", pair)
+		}
+		column, field := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch {
+		case field == "date", field == "type", field == "note", field == "value", field == "tags", field == "-":
+		case strings.HasPrefix(field, "data.") && len(field) > len("data."):
+		default:
+			return nil, fmt.Errorf("invalid field %q for column %q, must be date, type, note, value, tags, data.<key> or -", field, column)
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// ReadImport reads the entries to import and the rows they are from.
+// Problems with rows are returned as errors instead of stopping, so that
+// all of them can be reported at once.
+func ReadImport(r io.Reader, opts ImportOptions) ([]Entry, []int, []ImportError, error) {
+	importer, ok := importers[opts.Format]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unsupported import format %q, must be one of %s", opts.Format, strings.Join(importFormats(), ", "))
+	}
+
+	entries, rows, errs, err := importer.Read(r, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	failed := make(map[int]bool, len(errs))
+	for _, err := range errs {
+		failed[err.Row] = true
+	}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Type == "" {
+			entry.Type = opts.Type
+		}
+
+		switch {
+		case failed[rows[i]]:
+		case entry.Date.IsZero():
+			errs = append(errs, ImportError{Row: rows[i], Message: "missing date"})
+		case entry.Type == "":
+			errs = append(errs, ImportError{Row: rows[i], Message: "missing type"})
+		default:
+			if err := ValidateEntry(entry); err != nil {
+				errs = append(errs, ImportError{Row: rows[i], Message: "invalid entry: " + err.Error()})
+			}
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Row < errs[j].Row
+	})
+	return entries, rows, errs, nil
+}
+
+// csvImporter reads CSV files with a header, see ImportOptions.Mapping.
+type csvImporter struct{}
+
+func (csvImporter) Read(r io.Reader, opts ImportOptions) ([]Entry, []int, []ImportError, error) {
+	records, recordRows, errs, err := readCSVRecords(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil, fmt.Errorf("missing header")
+	}
+
+	header := records[0]
+	fields := make([]string, len(header))
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		field, ok := opts.Mapping[column]
+		switch {
+		case ok:
+		case column == "date", column == "type", column == "note", column == "value", column == "tags":
+			field = column
+		case column == "id", column == "":
+			field = "-"
+		case strings.HasPrefix(column, "data."):
+			field = column
+		default:
+			field = "data." + column
+		}
+		fields[i] = field
+	}
+
+	entries := make([]Entry, 0, len(records))
+	rows := make([]int, 0, len(records))
+	for n, record := range records[1:] {
+		row := recordRows[n+1]
+		entry := Entry{}
+		for i, val := range record {
+			if i >= len(fields) {
+				errs = append(errs, ImportError{Row: row, Message: fmt.Sprintf("more values than columns (%d)", len(fields))})
+				break
+			}
+
+			err := setImportField(&entry, fields[i], val)
+			if err != nil {
+				errs = append(errs, ImportError{Row: row, Message: fmt.Sprintf("column %q: %s", header[i], err)})
+			}
+		}
+		parseImportData(&entry, opts.Type)
+		entries = append(entries, entry)
+		rows = append(rows, row)
+	}
+	return entries, rows, errs, nil
+}
+
+// readCSVRecords reads all records and the rows they are in, counted like
+// in spreadsheets starting at 1.  Malformed records are returned as
+// errors.
+func readCSVRecords(r io.Reader) ([][]string, []int, []ImportError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	records := make([][]string, 0, 100)
+	rows := make([]int, 0, 100)
+	errs := make([]ImportError, 0)
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				errs = append(errs, ImportError{Row: row, Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, nil, fmt.Errorf("could not read csv: %s", err)
+		}
+		records = append(records, record)
+		rows = append(rows, row)
+	}
+	return records, rows, errs, nil
+}
+
+// setImportField sets the field of the entry from a CSV value.  Data
+// values are kept as text until the type of the entry is known, see
+// parseImportData.
+func setImportField(entry *Entry, field string, val string) error {
+	val = strings.TrimSpace(val)
+	switch field {
+	case "-":
+	case "date":
+		if val == "" {
+			return nil
+		}
+		date, err := parseImportDate(val)
+		if err != nil {
+			return err
+		}
+		entry.Date = date
+	case "type":
+		entry.Type = val
+	case "note":
+		entry.Note = val
+	case "tags":
+		entry.Tags = parseTags(val)
+	case "value":
+		if val == "" {
+			return nil
+		}
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", val)
+		}
+		entry.Value = v
+	default:
+		if val == "" {
+			return nil
+		}
+		if entry.Data == nil {
+			entry.Data = map[string]interface{}{}
+		}
+
+		entry.Data[strings.TrimPrefix(field, "data.")] = val
+	}
+	return nil
+}
+
+// parseImportData converts the data values read by setImportField
+// according to the fields of the type of the entry, like in forms.
+func parseImportData(entry *Entry, defaultType string) {
+	typ := entry.Type
+	if typ == "" {
+		typ = defaultType
+	}
+	td := entryTypes.Get(typ)
+	for key, raw := range entry.Data {
+		if val, ok := td.ParseField(key, []string{raw.(string)}); ok {
+			entry.Data[key] = val
+		}
+	}
+}
+
+// importDateLayouts are the date formats accepted in imports, dates
+// without a time zone are taken to be in UTC.
+var importDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseImportDate(val string) (time.Time, error) {
+	for _, layout := range importDateLayouts {
+		date, err := time.Parse(layout, val)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date like 2019-10-01 08:30 or 2019-10-01T08:30:00Z", val)
+}
+
+// jsonImporter reads JSON arrays of entries.
+type jsonImporter struct{}
+
+func (jsonImporter) Read(r io.Reader, opts ImportOptions) ([]Entry, []int, []ImportError, error) {
+	var raw []json.RawMessage
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid json, must be an array of entries: %s", err)
+	}
+
+	entries := make([]Entry, 0, len(raw))
+	rows := make([]int, 0, len(raw))
+	errs := make([]ImportError, 0)
+	for i, msg := range raw {
+		var entry Entry
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&entry)
+		if err != nil {
+			errs = append(errs, ImportError{Row: i + 1, Message: err.Error()})
+		}
+
+		entry.ID = ""
+		entry.DeletedAt = nil
+		entry.Version = 0
+		entries = append(entries, entry)
+		rows = append(rows, i+1)
+	}
+	return entries, rows, errs, nil
+}
+
+// Import reads entries and imports them unless there are errors, see
+// Repository.Import.
+func Import(ctx context.Context, repo Repository, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	entries, rows, errs, err := ReadImport(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{
+		DryRun:     opts.DryRun,
+		Total:      len(entries),
+		Duplicates: []int{},
+		Errors:     errs,
+	}
+	if len(errs) > 0 {
+		return result, nil
+	}
+
+	duplicates, err := repo.Import(ctx, entries, opts.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range duplicates {
+		result.Duplicates = append(result.Duplicates, rows[idx])
+	}
+	result.Imported = len(entries) - len(duplicates)
+	return result, nil
+}
+
+// importFormat guesses the format from the content type or file name.
+func importFormat(contentType string, fileName string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json", strings.EqualFold(path.Ext(fileName), ".json"):
+		return "json"
+	default:
+		return "csv"
+	}
+}
+
+// parseImportOptions reads the options from the `format`, `map`, `type`
+// and `dry_run` parameters.
+func parseImportOptions(req *http.Request, format string) (ImportOptions, error) {
+	opts := ImportOptions{
+		Format: format,
+		Type:   strings.TrimSpace(req.FormValue("type")),
+		DryRun: req.FormValue("dry_run") != "",
+	}
+	if f := req.FormValue("format"); f != "" {
+		opts.Format = f
+	}
+
+	var err error
+	opts.Mapping, err = parseMapping(req.FormValue("map"))
+	return opts, err
+}
+
+// renderImport shows the import form, or the result of an import posted
+// from it.
+func renderImport(repo Repository, w http.ResponseWriter, req *http.Request) {
+	var result *ImportResult
+	var err error
+	if req.Method == "POST" {
+		req.Body = http.MaxBytesReader(w, req.Body, maxImportSize)
+		result, err = importForm(repo, req)
+		if err != nil {
+			log.Printf("Could not import: %s", err)
+		}
+
+		if wantsJSON(req) {
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(result)
+			return
+		}
+	}
+
+	err = tmplImport.Execute(w, map[string]interface{}{
+		"Title":   "Import - daily",
+		"Form":    req.Form,
+		"Formats": importFormats(),
+		"Result":  result,
+		"Error":   err,
+	})
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+	}
+}
+
+func importForm(repo Repository, req *http.Request) (*ImportResult, error) {
+	err := req.ParseMultipartForm(maxImportSize)
+	if err != nil && err != http.ErrNotMultipart {
+		return nil, fmt.Errorf("invalid form: %s", err)
+	}
+
+	var r io.Reader = strings.NewReader(req.FormValue("data"))
+	fileName := ""
+	file, header, err := req.FormFile("file")
+	if err == nil {
+		defer file.Close()
+		r = file
+		fileName = header.Filename
+	} else if err != http.ErrMissingFile && err != http.ErrNotMultipart {
+		return nil, fmt.Errorf("could not read file: %s", err)
+	}
+
+	opts, err := parseImportOptions(req, importFormat("", fileName))
+	if err != nil {
+		return nil, err
+	}
+	return Import(req.Context(), repo, r, opts)
+}
+
+var tmplImport = template.Must(tmplBase.New("import").Parse(`{{ template "html-start" . }}
+	<h1>Import</h1>
+
+	{{ if .Error }}
+	<div class="error">
+		<pre>{{ .Error }}</pre>
+	</div>
+	{{ end }}
+
+	{{ with .Result }}
+	<section class="import-result">
+		{{ if .Errors }}
+		<p>Nothing was imported, fix these problems first:</p>
+		<ul class="errors">
+		{{ range .Errors }}
+			<li>Row {{ .Row }}: {{ .Message }}</li>
+		{{ end }}
+		</ul>
+		{{ else }}
+		<p>{{ if .DryRun }}Would import{{ else }}Imported{{ end }} {{ .Imported }} of {{ .Total }} entries.</p>
+		{{ if .Duplicates }}
+		<p>Skipped duplicates in rows {{ range $i, $row := .Duplicates }}{{ if $i }}, {{ end }}{{ $row }}{{ end }}.</p>
+		{{ end }}
+		{{ end }}
+	</section>
+	{{ end }}
+
+	<form method="POST" action="/import" enctype="multipart/form-data">
+		<div>
+			<input type="file" name="file" accept=".csv,.json,text/csv,application/json" />
+		</div>
+		<div>
+			<textarea name="data" cols="80" rows="10" placeholder="or paste CSV or JSON here">{{ .Form.Get "data" }}</textarea>
+		</div>
+		<div>
+			<select name="format">
+				<option value="">format from file name (csv by default)</option>
+				{{ range .Formats }}
+				<option value="{{ . }}" {{ if eq ($.Form.Get "format") . }}selected{{ end }}>{{ . }}</option>
+				{{ end }}
+			</select>
+			<input name="map" value="{{ .Form.Get "map" }}" placeholder="Datum=date, Was=type, Ort=data.location" />
+			<input name="type" value="{{ .Form.Get "type" }}" placeholder="type for rows without one" />
+		</div>
+		<label><input type="checkbox" name="dry_run" value="1" {{ if or (not .Result) (.Form.Get "dry_run") }}checked{{ end }} /> Dry run</label>
+		<input type="submit" value="Import" />
+	</form>
+{{ template "html-end" }}
+`))
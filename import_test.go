@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportCSV(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	_, err := repo.Create(ctx, &Entry{Date: time.Date(2019, 10, 1, 8, 30, 0, 0, time.UTC), Type: "coffee", Value: 2})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	mapping, err := parseMapping("Datum=date, Anzahl=value, Junk=-")
+	if err != nil {
+		t.Fatalf("could not parse mapping: %s", err)
+	}
+	opts := ImportOptions{Format: "csv", Mapping: mapping, Type: "coffee", DryRun: true}
+
+	csv := `Datum,Anzahl,note,milk,Junk
+2019-10-01 08:30,2,,oat,x
+2019-10-01 14:00,1,"second, ""flat white""",oat,x
+2019-10-01 14:00,1,"second, ""flat white""",oat,x
+2019-10-02,1.5,,,x
+`
+	result, err := Import(ctx, repo, strings.NewReader(csv), opts)
+	if err != nil {
+		t.Fatalf("could not import: %s", err)
+	}
+	expected := &ImportResult{DryRun: true, Total: 4, Imported: 2, Duplicates: []int{2, 4}, Errors: []ImportError{}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v, but got %#v", expected, result)
+	}
+
+	entries, err := repo.Find(ctx, Filter{})
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("dry run should not import anything, but got %d entries", len(entries))
+	}
+
+	opts.DryRun = false
+	result, err = Import(ctx, repo, strings.NewReader(csv), opts)
+	if err != nil {
+		t.Fatalf("could not import: %s", err)
+	}
+	if result.Imported != 2 {
+		t.Fatalf("expected 2 imported entries, but got %#v", result)
+	}
+
+	entries, err = repo.Find(ctx, Filter{Types: []string{"coffee"}, NoteContains: "flat white"})
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Data["milk"] != "oat" || entries[0].Value != 1 {
+		t.Fatalf("unexpected imported entries %#v", entries)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	csv := `date,type,value
+2019-10-01,coffee,2
+yesterday,coffee,1
+2019-10-02,,lots
+2019-10-03,mood,0.5
+`
+	result, err := Import(ctx, repo, strings.NewReader(csv), ImportOptions{Format: "csv"})
+	if err != nil {
+		t.Fatalf("could not import: %s", err)
+	}
+
+	rows := []int{}
+	for _, err := range result.Errors {
+		rows = append(rows, err.Row)
+	}
+	if !reflect.DeepEqual(rows, []int{3, 4}) || result.Imported != 0 {
+		t.Errorf("expected errors in rows 3 and 4 and nothing imported, but got %#v", result)
+	}
+
+	json := `[{"date": "2019-10-01T08:30:00Z", "type": "coffee"}, {"type": "coffee"}, {"date": "2019-10-01T08:30:00Z", "type": "mood", "colour": "blue"}]`
+	result, err = Import(ctx, repo, strings.NewReader(json), ImportOptions{Format: "json"})
+	if err != nil {
+		t.Fatalf("could not import: %s", err)
+	}
+	if len(result.Errors) != 2 || result.Errors[0].Row != 2 || result.Errors[1].Row != 3 {
+		t.Errorf("expected errors in entries 2 and 3, but got %#v", result.Errors)
+	}
+
+	entries, err := repo.Find(ctx, Filter{})
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("nothing should have been imported, but got %d entries", len(entries))
+	}
+}
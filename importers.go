@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Importer reads entries from an export, e.g. of another tracker.  rows
+// are the rows the entries come from, for reporting errors.  Problems
+// with single rows are returned as errors instead of stopping, so that
+// all of them can be reported at once.
+type Importer interface {
+	Read(r io.Reader, opts ImportOptions) (entries []Entry, rows []int, errs []ImportError, err error)
+}
+
+// importers are the supported import formats by name.
+var importers = map[string]Importer{
+	"csv":        csvImporter{},
+	"json":       jsonImporter{},
+	"daylio":     daylioImporter{},
+	"loop":       loopImporter{},
+	"date-value": dateValueImporter{},
+}
+
+func importFormats() []string {
+	formats := make([]string, 0, len(importers))
+	for format := range importers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// typeName turns names like "Step count" into types like "step-count".
+func typeName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// csvColumnIndex returns the index of each column in the header, by lower
+// case name.
+func csvColumnIndex(header []string) map[string]int {
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	return index
+}
+
+func csvField(record []string, index map[string]int, column string) string {
+	i, ok := index[column]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// daylioImporter reads the CSV export of Daylio, with one mood entry per
+// row.  The moods are mapped to values between 0 (awful) and 1 (rad),
+// activities are stored as a list in the data.
+type daylioImporter struct{}
+
+var daylioMoods = map[string]float64{
+	"awful": 0,
+	"bad":   0.25,
+	"meh":   0.5,
+	"good":  0.75,
+	"rad":   1,
+}
+
+func (daylioImporter) Read(r io.Reader, opts ImportOptions) ([]Entry, []int, []ImportError, error) {
+	records, recordRows, errs, err := readCSVRecords(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil, fmt.Errorf("missing header")
+	}
+
+	index := csvColumnIndex(records[0])
+	for _, column := range []string{"date", "time", "mood"} {
+		if _, ok := index[column]; !ok {
+			return nil, nil, nil, fmt.Errorf("missing column %q, is this a Daylio export?", column)
+		}
+	}
+
+	entries := make([]Entry, 0, len(records))
+	rows := make([]int, 0, len(records))
+	for n, record := range records[1:] {
+		row := recordRows[n+1]
+
+		date, err := daylioDate(record, index)
+		if err != nil {
+			errs = append(errs, ImportError{Row: row, Message: err.Error()})
+			continue
+		}
+
+		mood := csvField(record, index, "mood")
+		value, ok := daylioMoods[strings.ToLower(mood)]
+		if !ok {
+			errs = append(errs, ImportError{Row: row, Message: fmt.Sprintf("unknown mood %q, only the default moods are supported", mood)})
+			continue
+		}
+
+		entry := Entry{
+			Date:  date,
+			Type:  "mood",
+			Value: value,
+			Note:  csvField(record, index, "note"),
+			Data:  map[string]interface{}{"mood": strings.ToLower(mood)},
+		}
+		if title := csvField(record, index, "note_title"); title != "" {
+			entry.Note = strings.TrimSpace(title + "\n" + entry.Note)
+		}
+
+		activities := []interface{}{}
+		for _, activity := range strings.Split(csvField(record, index, "activities"), "|") {
+			if activity = strings.TrimSpace(activity); activity != "" {
+				activities = append(activities, activity)
+			}
+		}
+		if len(activities) > 0 {
+			entry.Data["activities"] = activities
+		}
+
+		entries = append(entries, entry)
+		rows = append(rows, row)
+	}
+	return entries, rows, errs, nil
+}
+
+// daylioDate reads the date and time of a row, from `full_date` in newer
+// exports and from `year` and `date` ("Sep 30") in older ones.
+func daylioDate(record []string, index map[string]int) (time.Time, error) {
+	day := csvField(record, index, "full_date")
+	layout := "2006-01-02"
+	if day == "" {
+		day = csvField(record, index, "year") + " " + csvField(record, index, "date")
+		layout = "2006 Jan 2"
+	}
+
+	clock := strings.ToUpper(csvField(record, index, "time"))
+	for _, clockLayout := range []string{"15:04", "3:04 PM"} {
+		date, err := time.Parse(layout+" "+clockLayout, day+" "+clock)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q or time %q", day, clock)
+}
+
+// loopImporter reads Checkmarks.csv from the export of Loop Habit
+// Tracker, which has a date column and one column per habit.  Every
+// manual check becomes an entry with the habit as type and the value 1.
+// Values of numerical habits are stored multiplied by 1000 and are
+// converted back.
+type loopImporter struct{}
+
+// values in Checkmarks.csv, besides the numerical ones
+const (
+	loopYesManual  = 2
+	loopSkip       = 3
+	loopNumberUnit = 1000
+)
+
+func (loopImporter) Read(r io.Reader, opts ImportOptions) ([]Entry, []int, []ImportError, error) {
+	records, recordRows, errs, err := readCSVRecords(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil, fmt.Errorf("missing header")
+	}
+
+	header := records[0]
+	if len(header) < 2 || strings.ToLower(strings.TrimSpace(header[0])) != "date" {
+		return nil, nil, nil, fmt.Errorf("first column must be the date, is this Checkmarks.csv from Loop Habit Tracker?")
+	}
+
+	entries := make([]Entry, 0, len(records))
+	rows := make([]int, 0, len(records))
+	for n, record := range records[1:] {
+		row := recordRows[n+1]
+
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+		if err != nil {
+			errs = append(errs, ImportError{Row: row, Message: fmt.Sprintf("invalid date %q", record[0])})
+			continue
+		}
+
+		for i := 1; i < len(record) && i < len(header); i++ {
+			habit := strings.TrimSpace(header[i])
+			val := strings.TrimSpace(record[i])
+			if habit == "" || val == "" {
+				continue
+			}
+
+			num, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				errs = append(errs, ImportError{Row: row, Message: fmt.Sprintf("habit %q: %q is not a number", habit, val)})
+				continue
+			}
+
+			var value float64
+			switch {
+			case num == loopYesManual:
+				value = 1
+			case num > loopSkip:
+				value = float64(num) / loopNumberUnit
+			default:
+				// not done, unknown, skipped or only implied by the interval
+				continue
+			}
+
+			entries = append(entries, Entry{
+				Date:  date,
+				Type:  typeName(habit),
+				Value: value,
+				Data:  map[string]interface{}{"habit": habit},
+			})
+			rows = append(rows, row)
+		}
+	}
+	return entries, rows, errs, nil
+}
+
+// dateValueImporter reads CSV files with a date in the first column and
+// numbers in the others, like the daily summaries from Google Fit in
+// Google Takeout or simple "date,value" exports.  Each number becomes an
+// entry with the column name as type, or ImportOptions.Type if there is
+// only one.  Files without a header are read as "date,value".
+type dateValueImporter struct{}
+
+func (dateValueImporter) Read(r io.Reader, opts ImportOptions) ([]Entry, []int, []ImportError, error) {
+	records, recordRows, errs, err := readCSVRecords(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil, fmt.Errorf("no data")
+	}
+
+	header := []string{"date", "value"}
+	if _, err := parseImportDate(strings.TrimSpace(records[0][0])); err != nil {
+		header = records[0]
+		records = records[1:]
+		recordRows = recordRows[1:]
+	}
+
+	types := make([]string, len(header))
+	for i, column := range header {
+		types[i] = typeName(column)
+		if opts.Type != "" && len(header) == 2 {
+			types[i] = opts.Type
+		}
+	}
+
+	entries := make([]Entry, 0, len(records))
+	rows := make([]int, 0, len(records))
+	for n, record := range records {
+		row := recordRows[n]
+
+		date, err := parseImportDate(strings.TrimSpace(record[0]))
+		if err != nil {
+			errs = append(errs, ImportError{Row: row, Message: err.Error()})
+			continue
+		}
+
+		for i := 1; i < len(record) && i < len(header); i++ {
+			val := strings.TrimSpace(record[i])
+			if val == "" || types[i] == "" {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				errs = append(errs, ImportError{Row: row, Message: fmt.Sprintf("column %q: %q is not a number", header[i], val)})
+				continue
+			}
+
+			entries = append(entries, Entry{Date: date, Type: types[i], Value: value})
+			rows = append(rows, row)
+		}
+	}
+	return entries, rows, errs, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImporters(t *testing.T) {
+	day := time.Date(2019, 9, 30, 0, 0, 0, 0, time.UTC)
+
+	var testCases = []struct {
+		format  string
+		typ     string
+		data    string
+		entries []Entry
+		errRows []int
+	}{
+		{
+			format: "daylio",
+			data: `full_date,date,weekday,time,mood,activities,note_title,note
+2019-09-30,Sep 30,Monday,8:30 pm,good,work | friends,,long day
+2019-09-30,Sep 30,Monday,07:15,fantastic,,,
+`,
+			entries: []Entry{
+				{Date: day.Add(20*time.Hour + 30*time.Minute), Type: "mood", Value: 0.75, Note: "long day",
+					Data: map[string]interface{}{"mood": "good", "activities": []interface{}{"work", "friends"}}},
+			},
+			errRows: []int{3},
+		},
+		{
+			format: "daylio",
+			data: `year,date,weekday,time,mood,activities,note
+2019,Sep 30,Monday,8:30 am,Rad,,
+`,
+			entries: []Entry{
+				{Date: day.Add(8*time.Hour + 30*time.Minute), Type: "mood", Value: 1, Data: map[string]interface{}{"mood": "rad"}},
+			},
+		},
+		{
+			format: "loop",
+			data: `Date,Meditate,Glasses of water,
+2019-09-30,2,4500,
+2019-09-29,1,0,
+2019-09-28,0,-1,
+`,
+			entries: []Entry{
+				{Date: day, Type: "meditate", Value: 1, Data: map[string]interface{}{"habit": "Meditate"}},
+				{Date: day, Type: "glasses-of-water", Value: 4.5, Data: map[string]interface{}{"habit": "Glasses of water"}},
+			},
+		},
+		{
+			format: "date-value",
+			data: `Date,Step count,Distance (m)
+2019-09-30,8500,6123.5
+2019-09-29,,1
+`,
+			entries: []Entry{
+				{Date: day, Type: "step-count", Value: 8500},
+				{Date: day, Type: "distance-m", Value: 6123.5},
+				{Date: day.Add(-24 * time.Hour), Type: "distance-m", Value: 1},
+			},
+		},
+		{
+			format: "date-value",
+			typ:    "weight",
+			data: `2019-09-30,71.5
+2019-09-29,heavy
+`,
+			entries: []Entry{
+				{Date: day, Type: "weight", Value: 71.5},
+			},
+			errRows: []int{2},
+		},
+	}
+
+	for _, tc := range testCases {
+		entries, _, errs, err := importers[tc.format].Read(strings.NewReader(tc.data), ImportOptions{Format: tc.format, Type: tc.typ})
+		if err != nil {
+			t.Errorf("%s: could not read: %s", tc.format, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(entries, tc.entries) {
+			t.Errorf("%s: expected %#v, but got %#v", tc.format, tc.entries, entries)
+		}
+
+		errRows := []int{}
+		for _, err := range errs {
+			errRows = append(errRows, err.Row)
+		}
+		if tc.errRows == nil {
+			tc.errRows = []int{}
+		}
+		if !reflect.DeepEqual(errRows, tc.errRows) {
+			t.Errorf("%s: expected errors in rows %v, but got %v", tc.format, tc.errRows, errs)
+		}
+	}
+}
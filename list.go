@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+// ListQuery selects a page of entries.  Zero values for From and To mean
+// that the range is open on that side.
+type ListQuery struct {
+	From  time.Time
+	To    time.Time
+	Types []string
+	Tags  []string
+	Order order
+	Limit int
+
+	// Cursor continues the listing after or before a previous page.
+	Cursor *Cursor
+}
+
+// Cursor is a position in a listing, used for keyset pagination on
+// (date, id) so that pages stay stable while new entries are added.
+type Cursor struct {
+	Date time.Time
+	ID   string
+
+	// Backward selects the entries before the position instead of
+	// those after it.
+	Backward bool
+}
+
+// Page is the result of a ListQuery.  Next and Prev are nil if there are
+// no more entries in that direction.
+type Page struct {
+	Entries Entries
+	Next    *Cursor
+	Prev    *Cursor
+}
+
+func (c Cursor) String() string {
+	dir := "n"
+	if c.Backward {
+		dir = "p"
+	}
+	raw := fmt.Sprintf("%s:%d:%s", dir, c.Date.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "p") {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+
+	nanos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+
+	return &Cursor{
+		Date:     time.Unix(0, nanos).UTC(),
+		ID:       parts[2],
+		Backward: parts[0] == "p",
+	}, nil
+}
+
+// parseListQuery reads a ListQuery from the `from`, `to`, `type`, `tag`,
+// `order`, `limit` and `cursor` parameters.  Entries must have all of the
+// given tags.
+func parseListQuery(params url.Values) (ListQuery, error) {
+	q := ListQuery{
+		Order: Descending,
+		Limit: defaultListLimit,
+	}
+
+	// types can be given as several parameters or separated by commas
+	for _, types := range params["type"] {
+		for _, typ := range strings.Split(types, ",") {
+			if typ = strings.TrimSpace(typ); typ != "" {
+				q.Types = append(q.Types, typ)
+			}
+		}
+	}
+	q.Tags = parseTags(params["tag"]...)
+
+	var err error
+	q.From, err = parseDateParam(params.Get("from"), time.Time{})
+	if err != nil {
+		return q, fmt.Errorf("invalid from: %s", err)
+	}
+	q.To, err = parseEndDateParam(params.Get("to"))
+	if err != nil {
+		return q, fmt.Errorf("invalid to: %s", err)
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Order = Ascending
+	default:
+		return q, fmt.Errorf("invalid order %q, must be asc or desc", params.Get("order"))
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 1 || q.Limit > maxListLimit {
+			return q, fmt.Errorf("invalid limit %q, must be between 1 and %d", limit, maxListLimit)
+		}
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		q.Cursor, err = parseCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// parseDateParam parses either a full RFC 3339 timestamp or a plain date,
+// returning def if the parameter is empty.
+func parseDateParam(param string, def time.Time) (time.Time, error) {
+	if param == "" {
+		return def, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, param)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse("2006-01-02", param)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date nor an RFC 3339 timestamp", param)
+	}
+	return t, nil
+}
+
+// parseEndDateParam parses the end of a range like parseDateParam, but a
+// plain date includes the whole day.
+func parseEndDateParam(param string) (time.Time, error) {
+	t, err := parseDateParam(param, time.Time{})
+	if err != nil || !isPlainDate(param) {
+		return t, err
+	}
+	return endOfDay(t), nil
+}
+
+// isPlainDate reports whether s is a date without a time, like 2019-10-01.
+func isPlainDate(s string) bool {
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+// endOfDay returns the last instant of the day that starts at t, so that
+// comparing with `date <= endOfDay(t)` is the same as `date < next day`.
+func endOfDay(t time.Time) time.Time {
+	return t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
+
+// pageURL returns u with the cursor parameter set to c, or "" if there
+// is no such page.
+func pageURL(u *url.URL, c *Cursor) string {
+	if c == nil {
+		return ""
+	}
+
+	params := u.Query()
+	params.Set("cursor", c.String())
+	return u.Path + "?" + params.Encode()
+}
+
+// linkHeader returns a Link header pointing to the next and previous
+// pages, as described in RFC 8288.
+func linkHeader(u *url.URL, page Page) string {
+	links := []string{}
+	if next := pageURL(u, page.Next); next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, next))
+	}
+	if prev := pageURL(u, page.Prev); prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, prev))
+	}
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// migration is a single step in the evolution of the schema, read from a
+// file named like "0002_soft_delete.sql".
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrationOptions are available in migrations, which are templates, e.g.
+// to only use FTS5 if it was compiled in.
+type migrationOptions struct {
+	FTS5 bool
+}
+
+// loadMigrations reads all *.sql files in the root of migrations, which
+// must be numbered consecutively starting at 1.
+func loadMigrations(migrations fs.FS) ([]migration, error) {
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("could not list migrations: %s", err)
+	}
+
+	result := make([]migration, 0, len(files))
+	for _, file := range files {
+		parts := strings.SplitN(file, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %q does not start with a version number", file)
+		}
+
+		f, err := migrations.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not open migration %q: %s", file, err)
+		}
+		migrationSQL, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration %q: %s", file, err)
+		}
+
+		tmpl, err := template.New(file).Parse(string(migrationSQL))
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration %q: %s", file, err)
+		}
+		buf := new(strings.Builder)
+		err = tmpl.Execute(buf, migrationOptions{FTS5: hasFTS5})
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration %q: %s", file, err)
+		}
+
+		result = append(result, migration{
+			version: version,
+			name:    file,
+			sql:     buf.String(),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+	for i, m := range result {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("expected migration %d, but got %q", i+1, m.name)
+		}
+	}
+
+	return result, nil
+}
+
+// migrate brings the schema of db up to date by applying all pending
+// migrations, each in its own transaction.  It refuses to touch a database
+// that has been migrated by a newer version of daily.
+func migrate(ctx context.Context, db *sql.DB, migrations []migration) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("could not create schema_version table: %s", err)
+	}
+
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		version, err = adoptLegacySchema(ctx, db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database has schema version %d, but this binary only knows up to version %d", version, len(migrations))
+	}
+
+	for _, m := range migrations[version:] {
+		log.Printf("Applying migration %q", m.name)
+
+		err := applyMigration(ctx, db, m)
+		if err != nil {
+			return fmt.Errorf("could not apply migration %q: %s", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func schemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("could not get schema version: %s", err)
+	}
+	return int(version.Int64), nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, m.sql)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_version (version, applied_at) VALUES (?, ?)",
+		m.version, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("could not record schema version: %s", err)
+	}
+
+	return tx.Commit()
+}
+
+// adoptLegacySchema figures out which migrations databases created from
+// the old schema-init.sql already contain and records that version.
+func adoptLegacySchema(ctx context.Context, db *sql.DB) (int, error) {
+	hasEntries, err := hasTable(ctx, db, "entries")
+	if err != nil || !hasEntries {
+		return 0, err
+	}
+
+	version := 1
+
+	var numDeletedAt int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('entries') WHERE name = 'deleted_at'").Scan(&numDeletedAt)
+	if err != nil {
+		return 0, fmt.Errorf("could not inspect entries table: %s", err)
+	}
+	if numDeletedAt > 0 {
+		version = 2
+
+		hasRevisions, err := hasTable(ctx, db, "entry_revisions")
+		if err != nil {
+			return 0, err
+		}
+		if hasRevisions {
+			version = 3
+		}
+	}
+
+	log.Printf("Adopting existing database at schema version %d", version)
+
+	_, err = db.ExecContext(ctx, "INSERT INTO schema_version (version, applied_at) VALUES (?, ?)",
+		version, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("could not record schema version: %s", err)
+	}
+
+	return version, nil
+}
+
+func hasTable(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("could not check for table %q: %s", name, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestMigrateLegacySchema(t *testing.T) {
+	db, err := sql.Open("sqlite3_daily", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %s", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	_, err = db.ExecContext(ctx, `CREATE TABLE entries (id VARCHAR(16) PRIMARY KEY, date TIMESTAMP NOT NULL,
+		type TEXT NOT NULL, note TEXT NOT NULL, value FLOAT, data TEXT)`)
+	if err != nil {
+		t.Fatalf("could not create legacy schema: %s", err)
+	}
+
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		t.Fatalf("could not load migrations: %s", err)
+	}
+
+	err = migrate(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("could not migrate: %s", err)
+	}
+
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("expected schema version %d, but got %d", len(migrations), version)
+	}
+
+	// running again must be a no-op
+	err = migrate(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("could not migrate again: %s", err)
+	}
+
+	err = migrate(ctx, db, migrations[:1])
+	if err == nil {
+		t.Fatal("migrating a newer database should fail")
+	}
+}
+
+func TestMigrateDataTags(t *testing.T) {
+	db, err := sql.Open("sqlite3_daily", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open db: %s", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		t.Fatalf("could not load migrations: %s", err)
+	}
+
+	// tags used to be kept in the additional data before 0007
+	ctx := context.Background()
+	err = migrate(ctx, db, migrations[:6])
+	if err != nil {
+		t.Fatalf("could not migrate: %s", err)
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO entries (id, date, type, note, value, data) VALUES
+		('a', '2019-10-01', 'coffee', '', 1, '{"tags":["work","cafe"],"milk":"oat"}'),
+		('b', '2019-10-01', 'coffee', '', 1, '{"tags":"home"}'),
+		('c', '2019-10-01', 'coffee', '', 1, '{"milk":"oat"}')`)
+	if err != nil {
+		t.Fatalf("could not insert entries: %s", err)
+	}
+
+	err = migrate(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("could not migrate: %s", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT id, "+tagsColumn+", data FROM entries ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	expected := []struct{ tags, data string }{
+		{"cafe work", `{"milk":"oat"}`},
+		{"home", "null"},
+		{"", `{"milk":"oat"}`},
+	}
+	for i := 0; rows.Next(); i++ {
+		var id, data string
+		var tags sql.NullString
+		err := rows.Scan(&id, &tags, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if joined := strings.Join(splitTags(tags.String), " "); joined != expected[i].tags || data != expected[i].data {
+			t.Errorf("%s: expected tags %q and data %s, but got %q and %s", id, expected[i].tags, expected[i].data, joined, data)
+		}
+	}
+}
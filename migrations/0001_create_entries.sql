@@ -1,4 +1,4 @@
-CREATE TABLE IF NOT EXISTS entries (
+CREATE TABLE entries (
 	`id`    VARCHAR(16) PRIMARY KEY,
 	`date`  TIMESTAMP NOT NULL,
 	`type`  TEXT NOT NULL,
@@ -0,0 +1 @@
+ALTER TABLE entries ADD COLUMN `deleted_at` TIMESTAMP;
@@ -0,0 +1,12 @@
+CREATE TABLE entry_revisions (
+	`id`         INTEGER PRIMARY KEY AUTOINCREMENT,
+	`entry_id`   VARCHAR(16) NOT NULL,
+	`revised_at` TIMESTAMP NOT NULL,
+	`date`       TIMESTAMP NOT NULL,
+	`type`       TEXT NOT NULL,
+	`note`       TEXT NOT NULL,
+	`value`      FLOAT,
+	`data`       TEXT
+);
+
+CREATE INDEX entry_revisions_entry_id ON entry_revisions (entry_id);
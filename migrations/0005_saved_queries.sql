@@ -0,0 +1,7 @@
+CREATE TABLE saved_queries (
+	`name`        TEXT PRIMARY KEY,
+	`description` TEXT NOT NULL DEFAULT '',
+	`query`       TEXT NOT NULL,
+	`params`      TEXT NOT NULL DEFAULT '[]',
+	`updated_at`  TIMESTAMP NOT NULL
+);
@@ -0,0 +1,25 @@
+-- full-text index over the notes and the string values in the additional
+-- data of all entries that are not deleted, kept in sync by the repository.
+-- FTS5 is only available if go-sqlite3 was built with the sqlite_fts5
+-- tag, otherwise FTS4 is used.
+{{ if .FTS5 }}
+CREATE VIRTUAL TABLE entries_search USING fts5(
+	entry_id UNINDEXED,
+	note,
+	data,
+	tokenize = 'unicode61'
+);
+{{ else }}
+CREATE VIRTUAL TABLE entries_search USING fts4(
+	entry_id,
+	note,
+	data,
+	notindexed=entry_id,
+	tokenize=unicode61
+);
+{{ end }}
+
+INSERT INTO entries_search (entry_id, note, data)
+     SELECT id, note, data_text(data)
+       FROM entries
+      WHERE deleted_at IS NULL;
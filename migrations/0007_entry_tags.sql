@@ -0,0 +1,43 @@
+CREATE TABLE entry_tags (
+	`entry_id` VARCHAR(16) NOT NULL,
+	`tag`      TEXT NOT NULL,
+	PRIMARY KEY (entry_id, tag)
+);
+
+CREATE INDEX entry_tags_tag ON entry_tags (tag);
+
+-- revisions keep the tags like entries_search, separated by char(31)
+ALTER TABLE entry_revisions ADD COLUMN `tags` TEXT;
+
+-- tags used to be kept in the additional data, move them over
+WITH RECURSIVE split(entry_id, tag, rest) AS (
+	SELECT id, '', data_tags(data) || char(31)
+	  FROM entries
+	 WHERE data_tags(data) != ''
+	UNION ALL
+	SELECT entry_id,
+	       substr(rest, 1, instr(rest, char(31)) - 1),
+	       substr(rest, instr(rest, char(31)) + 1)
+	  FROM split
+	 WHERE rest != ''
+)
+INSERT OR IGNORE INTO entry_tags (entry_id, tag)
+     SELECT entry_id, tag
+       FROM split
+      WHERE tag != '';
+
+UPDATE entries
+   SET data = data_without(data, 'tags')
+ WHERE data_tags(data) != '';
+
+UPDATE entry_revisions
+   SET tags = data_tags(data), data = data_without(data, 'tags')
+ WHERE data_tags(data) != '';
+
+-- tags are searchable along with the additional data
+DELETE FROM entries_search;
+
+INSERT INTO entries_search (entry_id, note, data)
+     SELECT id, note, trim(data_text(data) || ' ' || ifnull(replace((SELECT group_concat(tag, char(31)) FROM entry_tags WHERE entry_id = entries.id), char(31), ' '), ''))
+       FROM entries
+      WHERE deleted_at IS NULL;
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patchType returns the kind of patch described by the Content-Type
+// header, plain JSON is treated as a merge patch.
+func patchType(contentType string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case mergePatchType, "application/json":
+		return mergePatchType, nil
+	case jsonPatchType:
+		return jsonPatchType, nil
+	default:
+		return "", fmt.Errorf("unsupported patch type %q, use %s or %s", contentType, mergePatchType, jsonPatchType)
+	}
+}
+
+// ApplyPatch changes entry according to a JSON Merge Patch (RFC 7396) or
+// JSON Patch (RFC 6902) over the JSON representation of the entry.  The
+// id, version and deletion status of the entry can't be patched.
+func ApplyPatch(entry *Entry, typ string, patch []byte) error {
+	var doc interface{}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("could not serialize entry: %s", err)
+	}
+	err = json.Unmarshal(buf, &doc)
+	if err != nil {
+		return fmt.Errorf("could not serialize entry: %s", err)
+	}
+
+	switch typ {
+	case mergePatchType:
+		var p interface{}
+		err = json.Unmarshal(patch, &p)
+		if err != nil {
+			return fmt.Errorf("invalid merge patch: %s", err)
+		}
+		doc = mergePatch(doc, p)
+	case jsonPatchType:
+		var ops []jsonPatchOp
+		err = json.Unmarshal(patch, &ops)
+		if err != nil {
+			return fmt.Errorf("invalid json patch: %s", err)
+		}
+		doc, err = jsonPatch(doc, ops)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported patch type %q", typ)
+	}
+
+	buf, err = json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("could not serialize patched entry: %s", err)
+	}
+
+	var patched Entry
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patched)
+	if err != nil {
+		return fmt.Errorf("patched entry is invalid: %s", err)
+	}
+
+	patched.ID = entry.ID
+	patched.DeletedAt = entry.DeletedAt
+	patched.Version = entry.Version
+	*entry = patched
+	return nil
+}
+
+// mergePatch implements the MergePatch function from RFC 7396.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for key, val := range p {
+		if val == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], val)
+		}
+	}
+	return t
+}
+
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+// jsonPatch applies the operations from RFC 6902 in order, failing if any
+// one of them fails.
+func jsonPatch(doc interface{}, ops []jsonPatchOp) (interface{}, error) {
+	for i, op := range ops {
+		path, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i, err)
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = setPointer(doc, path, op.Value, true)
+		case "remove":
+			doc, err = removePointer(doc, path)
+		case "replace":
+			doc, err = setPointer(doc, path, op.Value, false)
+		case "move", "copy":
+			var from []string
+			from, err = parsePointer(op.From)
+			if err != nil {
+				break
+			}
+			var val interface{}
+			val, err = getPointer(doc, from)
+			if err != nil {
+				break
+			}
+			if op.Op == "move" {
+				doc, err = removePointer(doc, from)
+				if err != nil {
+					break
+				}
+			} else {
+				val, err = deepCopy(val)
+				if err != nil {
+					break
+				}
+			}
+			doc, err = setPointer(doc, path, val, true)
+		case "test":
+			var val interface{}
+			val, err = getPointer(doc, path)
+			if err == nil && !reflect.DeepEqual(val, op.Value) {
+				err = fmt.Errorf("test failed for %q", op.Path)
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %s", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped
+// reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer %q, must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func getPointer(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			val, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("%q does not exist", token)
+			}
+			doc = val
+		case []interface{}:
+			idx, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[idx]
+		default:
+			return nil, fmt.Errorf("can't index %q into a scalar", token)
+		}
+	}
+	return doc, nil
+}
+
+// setPointer sets the value at path, returning the changed document.  If
+// insert is set, missing object members are created and values are
+// inserted into arrays, otherwise the value must already exist.
+func setPointer(doc interface{}, path []string, val interface{}, insert bool) (interface{}, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+
+	token := path[0]
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if len(path) == 1 {
+			if !ok && !insert {
+				return nil, fmt.Errorf("%q does not exist", token)
+			}
+			container[token] = val
+			return container, nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("%q does not exist", token)
+		}
+		child, err := setPointer(child, path[1:], val, insert)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+	case []interface{}:
+		if len(path) == 1 && insert {
+			idx := len(container)
+			if token != "-" {
+				var err error
+				idx, err = arrayIndex(token, len(container))
+				if err != nil {
+					return nil, err
+				}
+			}
+			container = append(container, nil)
+			copy(container[idx+1:], container[idx:])
+			container[idx] = val
+			return container, nil
+		}
+		idx, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		container[idx], err = setPointer(container[idx], path[1:], val, insert)
+		if err != nil {
+			return nil, err
+		}
+		return container, nil
+	default:
+		return nil, fmt.Errorf("can't index %q into a scalar", token)
+	}
+}
+
+func removePointer(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("can't remove the whole document")
+	}
+
+	token := path[0]
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if !ok {
+			return nil, fmt.Errorf("%q does not exist", token)
+		}
+		if len(path) == 1 {
+			delete(container, token)
+			return container, nil
+		}
+		child, err := removePointer(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+	case []interface{}:
+		idx, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			return append(container[:idx], container[idx+1:]...), nil
+		}
+		container[idx], err = removePointer(container[idx], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		return container, nil
+	default:
+		return nil, fmt.Errorf("can't index %q into a scalar", token)
+	}
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > max || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return idx, nil
+}
+
+func deepCopy(val interface{}) (interface{}, error) {
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var c interface{}
+	err = json.Unmarshal(buf, &c)
+	return c, err
+}
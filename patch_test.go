@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	entry := Entry{
+		ID:    "abc",
+		Date:  time.Date(2019, 10, 1, 8, 30, 0, 0, time.UTC),
+		Type:  "coffee",
+		Note:  "first of the day",
+		Value: 1,
+		Data:  map[string]interface{}{"milk": "oat", "size": "large"},
+	}
+
+	err := ApplyPatch(&entry, mergePatchType, []byte(`{"id": "other", "value": 2, "data": {"size": null, "location": "home"}}`))
+	if err != nil {
+		t.Fatalf("could not apply patch: %s", err)
+	}
+
+	if entry.ID != "abc" || entry.Value != 2 || entry.Note != "first of the day" {
+		t.Fatalf("unexpected entry after patch: %#v", entry)
+	}
+	if len(entry.Data) != 2 || entry.Data["milk"] != "oat" || entry.Data["location"] != "home" {
+		t.Fatalf("unexpected data after patch: %#v", entry.Data)
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	entry := Entry{
+		Type: "coffee",
+		Data: map[string]interface{}{"tags": []interface{}{"work"}},
+	}
+
+	err := ApplyPatch(&entry, jsonPatchType, []byte(`[
+		{"op": "test", "path": "/type", "value": "coffee"},
+		{"op": "add", "path": "/data/tags/-", "value": "tired"},
+		{"op": "copy", "from": "/data/tags/0", "path": "/note"},
+		{"op": "move", "from": "/data/tags", "path": "/data/labels"}
+	]`))
+	if err != nil {
+		t.Fatalf("could not apply patch: %s", err)
+	}
+
+	labels, ok := entry.Data["labels"].([]interface{})
+	if !ok || len(labels) != 2 || labels[1] != "tired" || entry.Note != "work" {
+		t.Fatalf("unexpected entry after patch: %#v", entry)
+	}
+
+	err = ApplyPatch(&entry, jsonPatchType, []byte(`[{"op": "test", "path": "/type", "value": "tea"}]`))
+	if err == nil {
+		t.Fatal("failed test operation should fail the patch")
+	}
+}
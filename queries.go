@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SavedQuery is SQL stored under a name, with parameters that are bound
+// when it is run.
+type SavedQuery struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Query       string       `json:"query"`
+	Params      []QueryParam `json:"params"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// QueryParam is a declared parameter of a saved query, referenced as
+// `:name` in the SQL.  Parameters without a default must be given.
+type QueryParam struct {
+	Name    string `json:"name"`
+	Default string `json:"default,omitempty"`
+}
+
+var (
+	queryNamePattern  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	paramNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	paramUsagePattern = regexp.MustCompile(`^:[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+// parseParamDecls parses declarations like "type, since=2019-10-01".
+func parseParamDecls(s string) ([]QueryParam, error) {
+	params := make([]QueryParam, 0, 2)
+	for _, decl := range strings.Split(s, ",") {
+		decl = strings.TrimSpace(decl)
+		if decl == "" {
+			continue
+		}
+
+		param := QueryParam{Name: decl}
+		if idx := strings.Index(decl, "="); idx != -1 {
+			param.Name = strings.TrimSpace(decl[:idx])
+			param.Default = strings.TrimSpace(decl[idx+1:])
+		}
+		param.Name = strings.TrimPrefix(param.Name, ":")
+		if !paramNamePattern.MatchString(param.Name) {
+			return nil, fmt.Errorf("invalid parameter name %q", param.Name)
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// ParamDecls is the inverse of parseParamDecls.
+func (q SavedQuery) ParamDecls() string {
+	decls := make([]string, len(q.Params))
+	for i, param := range q.Params {
+		decls[i] = param.Name
+		if param.Default != "" {
+			decls[i] += "=" + param.Default
+		}
+	}
+	return strings.Join(decls, ", ")
+}
+
+// sqlParamNames returns the names of the `:name` parameters used in query,
+// skipping string literals, quoted identifiers and comments.
+func sqlParamNames(query string) []string {
+	names := make([]string, 0, 2)
+	seen := map[string]bool{}
+	for i := 0; i < len(query); i++ {
+		switch query[i] {
+		case '\'', '"', '`':
+			end := strings.IndexByte(query[i+1:], query[i])
+			if end == -1 {
+				return names
+			}
+			i += end + 1
+		case '-':
+			if strings.HasPrefix(query[i:], "--") {
+				end := strings.IndexByte(query[i:], '\n')
+				if end == -1 {
+					return names
+				}
+				i += end
+			}
+		case '/':
+			if strings.HasPrefix(query[i:], "/*") {
+				end := strings.Index(query[i:], "*/")
+				if end == -1 {
+					return names
+				}
+				i += end + 1
+			}
+		case ':':
+			usage := paramUsagePattern.FindString(query[i:])
+			if usage == "" {
+				continue
+			}
+			if !seen[usage[1:]] {
+				seen[usage[1:]] = true
+				names = append(names, usage[1:])
+			}
+			i += len(usage) - 1
+		}
+	}
+	return names
+}
+
+// paramsOf declares the parameters used in query, without defaults.
+func paramsOf(query string) []QueryParam {
+	names := sqlParamNames(query)
+	params := make([]QueryParam, len(names))
+	for i, name := range names {
+		params[i] = QueryParam{Name: name}
+	}
+	return params
+}
+
+// Validate checks the name of the query and that the parameters used in
+// the SQL are exactly the declared ones.
+func (q SavedQuery) Validate() error {
+	if !queryNamePattern.MatchString(q.Name) {
+		return fmt.Errorf("invalid name %q, only letters, digits, - and _ are allowed", q.Name)
+	}
+	if strings.TrimSpace(q.Query) == "" {
+		return fmt.Errorf("query must not be empty")
+	}
+
+	declared := map[string]bool{}
+	for _, param := range q.Params {
+		if declared[param.Name] {
+			return fmt.Errorf("parameter %q is declared twice", param.Name)
+		}
+		declared[param.Name] = true
+	}
+
+	used := sqlParamNames(q.Query)
+	for _, name := range used {
+		if !declared[name] {
+			return fmt.Errorf("parameter %q is used but not declared", name)
+		}
+		delete(declared, name)
+	}
+	for _, param := range q.Params {
+		if declared[param.Name] {
+			return fmt.Errorf("parameter %q is declared but not used", param.Name)
+		}
+	}
+	return nil
+}
+
+// Args returns the parameters to bind, taken from values or the defaults.
+// Values that look like numbers are bound as numbers, so that they can be
+// used with LIMIT or in arithmetic.
+func (q SavedQuery) Args(values url.Values) ([]interface{}, error) {
+	args := make([]interface{}, len(q.Params))
+	for i, param := range q.Params {
+		val := param.Default
+		if _, ok := values[param.Name]; ok {
+			val = values.Get(param.Name)
+		}
+		if val == "" && param.Default == "" {
+			return nil, fmt.Errorf("missing parameter %q", param.Name)
+		}
+
+		if num, err := strconv.ParseInt(val, 10, 64); err == nil {
+			args[i] = sql.Named(param.Name, num)
+		} else if num, err := strconv.ParseFloat(val, 64); err == nil {
+			args[i] = sql.Named(param.Name, num)
+		} else {
+			args[i] = sql.Named(param.Name, val)
+		}
+	}
+	return args, nil
+}
+
+func renderSavedQueries(repo Repository, w http.ResponseWriter, req *http.Request) {
+	queries, err := repo.SavedQueries(req.Context())
+	if err != nil {
+		log.Printf("Could not list saved queries: %s", err)
+		http.Error(w, "could not list saved queries", http.StatusInternalServerError)
+		return
+	}
+
+	if wantsJSON(req) {
+		writeAPIJSON(w, http.StatusOK, queries)
+		return
+	}
+
+	err = tmplSavedQueries.Execute(w, queries)
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+	}
+}
+
+func saveQuery(repo Repository, w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := SavedQuery{
+		Name:        strings.TrimSpace(req.PostForm.Get("name")),
+		Description: strings.TrimSpace(req.PostForm.Get("description")),
+		Query:       strings.TrimSpace(req.PostForm.Get("query")),
+	}
+	query.Params, err = parseParamDecls(req.PostForm.Get("params"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = query.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = repo.SaveQuery(req.Context(), &query)
+	if err != nil {
+		log.Printf("Could not save query %q: %s", query.Name, err)
+		http.Error(w, "could not save query", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, req, "/query/"+query.Name, http.StatusFound)
+}
+
+// runSavedQuery runs the saved query with parameters from the URL, so that
+// e.g. `/query/coffee-per-day?since=2019-10-01&format=csv` can be used as
+// a permalink.
+func runSavedQuery(repo Repository, w http.ResponseWriter, req *http.Request, name string) {
+	query, err := repo.SavedQuery(req.Context(), name)
+	if err != nil {
+		log.Printf("Could not get saved query %q: %s", name, err)
+		http.Error(w, "could not get saved query", http.StatusInternalServerError)
+		return
+	}
+	if query == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), config.queryTimeout)
+	defer cancel()
+
+	params := req.URL.Query()
+	var table *Table = nil
+	args, err := query.Args(params)
+	if err == nil {
+		table, err = repo.QueryTable(ctx, query.Query, args...)
+		if err != nil {
+			log.Printf("Could not execute saved query %q: %s", name, err)
+		}
+	}
+
+	format := resultFormat(req)
+	if format != "html" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = writeTable(w, format, table)
+		if err != nil {
+			log.Printf("Could not render result: %s", err)
+		}
+		return
+	}
+
+	values := make(map[string]string, len(query.Params))
+	for _, param := range query.Params {
+		values[param.Name] = param.Default
+		if _, ok := params[param.Name]; ok {
+			values[param.Name] = params.Get(param.Name)
+		}
+	}
+
+	err = tmplSavedQuery.Execute(w, map[string]interface{}{
+		"SavedQuery": query,
+		"Values":     values,
+		"Params":     params,
+		"Table":      table,
+		"Error":      err,
+	})
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+		fmt.Fprintf(w, "\n%s\n", err)
+	}
+}
+
+func deleteSavedQuery(repo Repository, w http.ResponseWriter, req *http.Request, name string) {
+	err := repo.DeleteSavedQuery(req.Context(), name)
+	if err != nil {
+		log.Printf("Could not delete saved query %q: %s", name, err)
+		http.Error(w, "could not delete saved query", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, req, "/queries", http.StatusFound)
+}
+
+var tmplSavedQueries = template.Must(template.New("saved-queries").Parse(`<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8" />
+	<title>Saved queries</title>
+</head>
+
+<body>
+	<h1>Saved queries</h1>
+
+	<ul class="saved-queries">
+	{{ range . }}
+		<li>
+			<a href="/query/{{ .Name }}">{{ .Name }}</a>
+			{{ range .Params }}<code>:{{ .Name }}</code> {{ end }}
+			{{ if .Description }}<p>{{ .Description }}</p>{{ end }}
+		</li>
+	{{ else }}
+		<li>No saved queries yet, write one on <a href="/query">/query</a>.</li>
+	{{ end }}
+	</ul>
+</body>
+</html>
+`))
+
+var tmplSavedQuery = template.Must(tmplTable.New("saved-query").Parse(`<!doctype html>
+<html>
+<head>
+	<meta charset="utf-8" />
+	<title>{{ .SavedQuery.Name }}</title>
+</head>
+
+<body>
+	{{ with .SavedQuery }}
+	<h1>{{ .Name }}</h1>
+	{{ if .Description }}<p>{{ .Description }}</p>{{ end }}
+	<pre>{{ .Query }}</pre>
+	{{ end }}
+
+	{{ if .SavedQuery.Params }}
+	<form method="GET" action="/query/{{ .SavedQuery.Name }}">
+		{{ range .SavedQuery.Params }}
+		<label>{{ .Name }} <input name="{{ .Name }}" value="{{ index $.Values .Name }}" placeholder="{{ .Default }}" /></label>
+		{{ end }}
+		<input type="submit" value="Run" />
+	</form>
+	{{ end }}
+
+	{{ if .Error }}
+	<div class="error">
+		<pre>{{ .Error }}</pre>
+	</div>
+	{{ end }}
+
+	{{ if .Table }}
+	{{ template "table" .Table }}
+	<a href="?{{ .Params.Encode }}&amp;format=csv">csv</a>
+	<a href="?{{ .Params.Encode }}&amp;format=json">json</a>
+	{{ end }}
+
+	<details>
+		<summary>Edit</summary>
+		{{ template "save-query" .SavedQuery }}
+		<form method="POST" action="/query/{{ .SavedQuery.Name }}/delete">
+			<input type="submit" value="Delete" />
+		</form>
+	</details>
+
+	<a href="/queries">All saved queries</a>
+</body>
+</html>
+
+{{ define "save-query" }}
+<form method="POST" action="/queries" class="save-query">
+	<div>
+		<textarea name="query" cols="80" rows="10" required>{{ .Query }}</textarea>
+	</div>
+	<input name="name" placeholder="name" value="{{ .Name }}" required />
+	<input name="params" placeholder="params, e.g. type, since=2019-10-01" value="{{ .ParamDecls }}" />
+	<input name="description" placeholder="description" value="{{ .Description }}" />
+	<input type="submit" value="Save query" />
+</form>
+{{ end }}
+`))
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLParamNames(t *testing.T) {
+	var testCases = []struct {
+		query string
+		names []string
+	}{
+		{"SELECT * FROM entries", []string{}},
+		{"SELECT * FROM entries WHERE type = :type AND date >= :since", []string{"type", "since"}},
+		{"SELECT * FROM entries WHERE type = :type OR note = :type", []string{"type"}},
+		{"SELECT ':nope', \":nope\" -- :nope\n FROM entries /* :nope */ LIMIT :n", []string{"n"}},
+	}
+
+	for _, tc := range testCases {
+		names := sqlParamNames(tc.query)
+		if !reflect.DeepEqual(names, tc.names) {
+			t.Errorf("%q: expected %v, but got %v", tc.query, tc.names, names)
+		}
+	}
+}
+
+func TestSavedQuery(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, typ := range []string{"coffee", "coffee", "mood"} {
+		_, err := repo.Create(ctx, &Entry{Date: time.Now(), Type: typ})
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	params, err := parseParamDecls("type, limit=10")
+	if err != nil {
+		t.Fatalf("could not parse params: %s", err)
+	}
+	query := SavedQuery{
+		Name:   "count-type",
+		Query:  "SELECT count(*) FROM entries WHERE type = :type LIMIT :limit",
+		Params: params,
+	}
+	err = query.Validate()
+	if err != nil {
+		t.Fatalf("query should be valid: %s", err)
+	}
+	err = repo.SaveQuery(ctx, &query)
+	if err != nil {
+		t.Fatalf("could not save query: %s", err)
+	}
+
+	saved, err := repo.SavedQuery(ctx, "count-type")
+	if err != nil || saved == nil {
+		t.Fatalf("could not get saved query: %v, %s", saved, err)
+	}
+	if !reflect.DeepEqual(saved.Params, query.Params) {
+		t.Errorf("params were not saved, got %#v", saved.Params)
+	}
+
+	_, err = saved.Args(url.Values{})
+	if err == nil {
+		t.Errorf("missing parameter should fail")
+	}
+
+	// injection attempts are just values that don't match
+	for typ, count := range map[string]int64{"coffee": 2, "mood": 1, "' OR 1=1 --": 0} {
+		args, err := saved.Args(url.Values{"type": {typ}})
+		if err != nil {
+			t.Fatalf("could not get args: %s", err)
+		}
+		table, err := repo.QueryTable(ctx, saved.Query, args...)
+		if err != nil {
+			t.Fatalf("could not run saved query: %s", err)
+		}
+		if table.Rows[0][0] != count {
+			t.Errorf("expected %d entries of type %q, but got %v", count, typ, table.Rows[0][0])
+		}
+	}
+
+	invalid := SavedQuery{Name: "undeclared", Query: "SELECT * FROM entries WHERE type = :type"}
+	if invalid.Validate() == nil {
+		t.Errorf("undeclared parameter should be invalid")
+	}
+}
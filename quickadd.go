@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// QuickAddError describes why a quick-add line could not be parsed.  Pos
+// is the byte offset of the problematic part.
+type QuickAddError struct {
+	Pos     int
+	Message string
+}
+
+func (e *QuickAddError) Error() string {
+	return fmt.Sprintf("at %d: %s", e.Pos+1, e.Message)
+}
+
+type quickToken struct {
+	text   string
+	pos    int
+	quoted bool
+}
+
+// tokenizeQuickAdd splits the line at spaces, except inside of double
+// quotes.  Quotes may start a token or follow the = of key="value".
+func tokenizeQuickAdd(line string) ([]quickToken, error) {
+	tokens := make([]quickToken, 0, 8)
+	var current *quickToken
+	var text strings.Builder
+	quoteStart := -1
+	for i, r := range line {
+		switch {
+		case r == '"':
+			if current == nil {
+				current = &quickToken{pos: i, quoted: true}
+			}
+			if quoteStart == -1 {
+				quoteStart = i
+			} else {
+				quoteStart = -1
+			}
+		case r == ' ' || r == '\t':
+			if quoteStart != -1 {
+				text.WriteRune(r)
+			} else if current != nil {
+				current.text = text.String()
+				tokens = append(tokens, *current)
+				current = nil
+				text.Reset()
+			}
+		default:
+			if current == nil {
+				current = &quickToken{pos: i}
+			} else if current.quoted && quoteStart == -1 {
+				return nil, &QuickAddError{Pos: i, Message: "text after closing quote, put a space after it"}
+			}
+			text.WriteRune(r)
+		}
+	}
+	if quoteStart != -1 {
+		return nil, &QuickAddError{Pos: quoteStart, Message: "quote is not closed"}
+	}
+	if current != nil {
+		current.text = text.String()
+		tokens = append(tokens, *current)
+	}
+	return tokens, nil
+}
+
+var (
+	quickClockPattern = regexp.MustCompile(`^\d{1,2}:\d{2}$`)
+	quickDayPattern   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// ParseQuickAdd parses lines like `coffee 2 @08:30 #work oat milk` or
+// `mood 0.7 yesterday 22:00 "tired but ok" location=home` into an entry:
+//
+//   - the first word is the type
+//   - a number is the value
+//   - `today`, `yesterday`, dates like `2019-10-01` and times like `22:00`
+//     set the date, optionally prefixed with @ (`@2019-10-01T08:30`)
+//   - `#tag` adds a tag
+//   - `key=value` sets additional data, converted like in the forms
+//   - everything else, or anything in quotes, is the note
+//
+// Dates and times are relative to now and in its time zone.  Input that
+// could be read in more than one way, like two numbers, is an error.
+func ParseQuickAdd(line string, now time.Time) (*Entry, error) {
+	tokens, err := tokenizeQuickAdd(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &QuickAddError{Pos: 0, Message: "empty input, start with the type, e.g. `coffee 2`"}
+	}
+
+	first := tokens[0]
+	if _, err := parseNumber(first.text); err == nil || first.quoted ||
+		strings.ContainsAny(first.text, "@#=") || isQuickDate(first.text) {
+		return nil, &QuickAddError{Pos: first.pos, Message: fmt.Sprintf("%q is not a type, start with the type, e.g. `coffee 2`", first.text)}
+	}
+
+	entry := &Entry{Type: first.text}
+	var valueToken, dayToken, clockToken *quickToken
+	var day, clock time.Time
+	note := make([]string, 0, len(tokens))
+	tags := make([]string, 0)
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+		text := token.text
+		switch {
+		case token.quoted:
+			note = append(note, text)
+		case strings.HasPrefix(text, "#") && len(text) > 1:
+			tags = append(tags, text[1:])
+		case strings.HasPrefix(text, "@"):
+			d, c, err := parseQuickDate(text[1:], now)
+			if err != nil {
+				return nil, &QuickAddError{Pos: token.pos, Message: err.Error()}
+			}
+			if !d.IsZero() {
+				if dayToken != nil {
+					return nil, quickConflict(token, *dayToken, "day")
+				}
+				dayToken, day = &tokens[i], d
+			}
+			if !c.IsZero() {
+				if clockToken != nil {
+					return nil, quickConflict(token, *clockToken, "time")
+				}
+				clockToken, clock = &tokens[i], c
+			}
+		case isQuickDate(text):
+			d, c, err := parseQuickDate(text, now)
+			if err != nil {
+				return nil, &QuickAddError{Pos: token.pos, Message: err.Error()}
+			}
+			if !d.IsZero() {
+				if dayToken != nil {
+					return nil, quickConflict(token, *dayToken, "day")
+				}
+				dayToken, day = &tokens[i], d
+			} else {
+				if clockToken != nil {
+					return nil, quickConflict(token, *clockToken, "time")
+				}
+				clockToken, clock = &tokens[i], c
+			}
+		case strings.Contains(text, "="):
+			idx := strings.Index(text, "=")
+			if idx == 0 {
+				return nil, &QuickAddError{Pos: token.pos, Message: fmt.Sprintf("%q has no key, use key=value", text)}
+			}
+			if entry.Data == nil {
+				entry.Data = map[string]interface{}{}
+			}
+			key := text[:idx]
+			if _, ok := entry.Data[key]; ok {
+				return nil, &QuickAddError{Pos: token.pos, Message: fmt.Sprintf("%q is set twice", key)}
+			}
+			if val, ok := entryTypes.Get(entry.Type).ParseField(key, []string{text[idx+1:]}); ok {
+				entry.Data[key] = val
+			}
+		default:
+			if v, err := parseNumber(text); err == nil {
+				if valueToken != nil {
+					return nil, &QuickAddError{Pos: token.pos,
+						Message: fmt.Sprintf("both %s and %s could be the value, put the note in quotes", valueToken.text, text)}
+				}
+				valueToken = &tokens[i]
+				entry.Value = v
+				continue
+			}
+			note = append(note, text)
+		}
+	}
+
+	entry.Note = strings.Join(note, " ")
+	entry.Tags = normalizeTags(tags)
+
+	entry.Date = now
+	if dayToken != nil {
+		entry.Date = time.Date(day.Year(), day.Month(), day.Day(),
+			now.Hour(), now.Minute(), now.Second(), 0, now.Location())
+	}
+	if clockToken != nil {
+		entry.Date = time.Date(entry.Date.Year(), entry.Date.Month(), entry.Date.Day(),
+			clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	}
+	return entry, nil
+}
+
+func quickConflict(token, previous quickToken, what string) error {
+	return &QuickAddError{Pos: token.pos,
+		Message: fmt.Sprintf("the %s is given twice, as %q and %q", what, previous.text, token.text)}
+}
+
+func isQuickDate(text string) bool {
+	return text == "today" || text == "yesterday" || quickDayPattern.MatchString(text) || quickClockPattern.MatchString(text)
+}
+
+// parseQuickDate parses a day, a time or both (`2019-10-01T08:30`).  Parts
+// that are not given are zero.
+func parseQuickDate(text string, now time.Time) (day time.Time, clock time.Time, err error) {
+	if text == "" {
+		return day, clock, fmt.Errorf("@ must be followed by a date or time, e.g. @08:30")
+	}
+
+	dayPart, clockPart := text, ""
+	if idx := strings.Index(text, "T"); idx != -1 {
+		dayPart, clockPart = text[:idx], text[idx+1:]
+	} else if quickClockPattern.MatchString(text) {
+		dayPart, clockPart = "", text
+	}
+
+	switch {
+	case dayPart == "":
+	case dayPart == "today":
+		day = now
+	case dayPart == "yesterday":
+		day = now.AddDate(0, 0, -1)
+	default:
+		day, err = time.ParseInLocation("2006-01-02", dayPart, now.Location())
+		if err != nil {
+			return day, clock, fmt.Errorf("%q is not a date or time like 2019-10-01, yesterday or 08:30", dayPart)
+		}
+	}
+
+	if clockPart != "" {
+		clock, err = time.ParseInLocation("15:04", clockPart, now.Location())
+		if err != nil {
+			return day, clock, fmt.Errorf("%q is not a time like 08:30", clockPart)
+		}
+	}
+	return day, clock, nil
+}
+
+// renderQuickAdd shows the parsed entry for the `text` parameter, so that
+// it can be checked before saving.
+func renderQuickAdd(w http.ResponseWriter, req *http.Request) {
+	text := strings.TrimSpace(req.FormValue("text"))
+	var entry *Entry
+	var err error
+	if text != "" {
+		entry, err = ParseQuickAdd(text, time.Now())
+		if err == nil {
+			err = ValidateEntry(entry)
+		}
+	}
+
+	err = tmplQuickAdd.Execute(w, map[string]interface{}{
+		"Title": "Quick add - daily",
+		"Text":  text,
+		"Entry": entry,
+		"Error": err,
+	})
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+	}
+}
+
+// quickAdd creates the entry described by the `text` parameter, or shows
+// the problem with it.
+func quickAdd(repo Repository, w http.ResponseWriter, req *http.Request) {
+	text := strings.TrimSpace(req.FormValue("text"))
+	entry, err := ParseQuickAdd(text, time.Now())
+	status := http.StatusBadRequest
+	if err == nil {
+		err = ValidateEntry(entry)
+		status = http.StatusUnprocessableEntity
+	}
+	if err != nil {
+		w.WriteHeader(status)
+		err = tmplQuickAdd.Execute(w, map[string]interface{}{
+			"Title": "Quick add - daily",
+			"Text":  text,
+			"Error": err,
+		})
+		if err != nil {
+			log.Printf("Could not execute template: %s", err)
+		}
+		return
+	}
+
+	id, err := repo.Create(req.Context(), entry)
+	if err != nil {
+		log.Printf("Could not create entry: %s", err)
+		http.Error(w, fmt.Sprintf("Could not create new entry: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, req, "/"+id, http.StatusFound)
+}
+
+var tmplQuickAdd = template.Must(tmplBase.New("quick-add").Parse(`{{ template "html-start" . }}
+	<section id="content">
+		<h1>Quick add</h1>
+
+		<form method="GET" action="/quick">
+			<input name="text" value="{{ .Text }}" placeholder="coffee 2 @08:30 #work oat milk" autofocus autocomplete="off" />
+			<input type="submit" value="Preview" />
+			<input type="submit" value="Save" formmethod="POST" />
+		</form>
+
+		{{ if .Error }}
+		<div class="error">
+			<pre>{{ .Error }}</pre>
+		</div>
+		{{ end }}
+
+		{{ with .Entry }}
+		<dl class="preview">
+			<dt>Type</dt><dd>{{ .Type }}</dd>
+			<dt>Value</dt><dd>{{ .Value }}</dd>
+			<dt>Date</dt><dd>{{ .Date.Format "2006-01-02 15:04 MST" }}</dd>
+			{{ if .Note }}<dt>Note</dt><dd>{{ .Note }}</dd>{{ end }}
+			{{ with .Tags }}<dt>Tags</dt><dd>{{ range . }}#{{ . }} {{ end }}</dd>{{ end }}
+			{{ range $key, $val := .Data }}
+			<dt>{{ $key }}</dt><dd>{{ $val }}</dd>
+			{{ end }}
+		</dl>
+		{{ end }}
+	</section>
+{{ template "html-end" }}
+`))
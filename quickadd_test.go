@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuickAdd(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	now := time.Date(2019, 10, 8, 12, 15, 30, 0, loc)
+
+	var testCases = []struct {
+		line     string
+		expected Entry
+	}{
+		{"coffee", Entry{Type: "coffee", Date: now}},
+		{"coffee 2 @08:30 #work oat milk", Entry{Type: "coffee", Value: 2, Note: "oat milk",
+			Date: time.Date(2019, 10, 8, 8, 30, 0, 0, loc),
+			Tags: []string{"work"}}},
+		{"coffee #work #cafe #work", Entry{Type: "coffee", Date: now, Tags: []string{"cafe", "work"}}},
+		{`mood 0.7 yesterday 22:00 "tired but ok" location=home`, Entry{Type: "mood", Value: 0.7, Note: "tired but ok",
+			Date: time.Date(2019, 10, 7, 22, 0, 0, 0, loc),
+			Data: map[string]interface{}{"location": "home"}}},
+		{`water -1 @2019-10-01T00:00 cups=2.5 cold=true where="home office"`, Entry{Type: "water", Value: -1,
+			Date: time.Date(2019, 10, 1, 0, 0, 0, 0, loc),
+			Data: map[string]interface{}{"cups": "2.5", "cold": "true", "where": "home office"}}},
+		{`coffee 2019-10-01 "2 cups"`, Entry{Type: "coffee", Note: "2 cups",
+			Date: time.Date(2019, 10, 1, 12, 15, 30, 0, loc)}},
+		{"mood nan", Entry{Type: "mood", Note: "nan", Date: now}},
+		{"coffee 2 with inf milk=NaN", Entry{Type: "coffee", Value: 2, Note: "with inf", Date: now,
+			Data: map[string]interface{}{"milk": "NaN"}}},
+	}
+
+	for _, tc := range testCases {
+		entry, err := ParseQuickAdd(tc.line, now)
+		if err != nil {
+			t.Errorf("%q: %s", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(*entry, tc.expected) {
+			t.Errorf("%q: expected %#v, but got %#v", tc.line, tc.expected, *entry)
+		}
+	}
+
+	var errorCases = []struct {
+		line string
+		err  string
+	}{
+		{"", "empty input"},
+		{"2 coffee", "not a type"},
+		{"coffee 2 3", "both 2 and 3 could be the value"},
+		{"coffee @08:30 09:00", "time is given twice"},
+		{"coffee today yesterday", "day is given twice"},
+		{`mood "tired but ok`, "quote is not closed"},
+		{"coffee @noon", "not a date or time"},
+		{"coffee =oat", "has no key"},
+	}
+
+	for _, tc := range errorCases {
+		_, err := ParseQuickAdd(tc.line, now)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error containing %q, but got %v", tc.line, tc.err, err)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -21,6 +25,9 @@ func (es Entries) Render(w io.Writer, contentType string) error {
 	if strings.Contains(contentType, "html") {
 		return es.RenderHTML(w)
 	}
+	if strings.Contains(contentType, "csv") {
+		return es.RenderCSV(w)
+	}
 	return es.RenderJSON(w)
 }
 
@@ -48,6 +55,111 @@ func (es Entries) RenderJSONString() (string, error) {
 	return buf.String(), err
 }
 
+// RenderCSV writes the entries as CSV, with a column for every key in
+// their additional data.
+func (es Entries) RenderCSV(w io.Writer) error {
+	seen := map[string]bool{}
+	for _, e := range es {
+		for key := range e.Data {
+			seen[key] = true
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cw, err := NewCSVWriter(w, keys)
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		err = cw.Write(e)
+		if err != nil {
+			return err
+		}
+	}
+	return cw.Flush()
+}
+
+// CSVWriter writes entries as CSV rows, one at a time.  The additional
+// data is flattened into one column per key, lists are joined with "; "
+// and other non-string values are written as JSON.
+type CSVWriter struct {
+	w      *csv.Writer
+	keys   []string
+	record []string
+}
+
+var csvColumns = []string{"id", "date", "type", "note", "value", "tags"}
+
+// NewCSVWriter writes the header with the usual columns and the given
+// data keys.  Keys named like one of the usual columns are prefixed with
+// "data.".
+func NewCSVWriter(w io.Writer, keys []string) (*CSVWriter, error) {
+	cw := &CSVWriter{
+		w:      csv.NewWriter(w),
+		keys:   keys,
+		record: make([]string, len(csvColumns)+len(keys)),
+	}
+
+	header := append([]string{}, csvColumns...)
+	for _, key := range keys {
+		for _, column := range csvColumns {
+			if key == column {
+				key = "data." + key
+				break
+			}
+		}
+		header = append(header, key)
+	}
+	err := cw.w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *CSVWriter) Write(e Entry) error {
+	cw.record[0] = e.ID
+	cw.record[1] = e.Date.Format(time.RFC3339Nano)
+	cw.record[2] = e.Type
+	cw.record[3] = e.Note
+	cw.record[4] = strconv.FormatFloat(e.Value, 'f', -1, 64)
+	cw.record[5] = strings.Join(e.Tags, " ")
+	for i, key := range cw.keys {
+		cw.record[len(csvColumns)+i] = csvValue(e.Data[key])
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *CSVWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func csvValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			elems[i] = csvValue(elem)
+		}
+		return strings.Join(elems, "; ")
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(buf)
+	}
+}
+
 func (es Entries) RenderHTML(w io.Writer) error {
 	for _, e := range es {
 		e.Date = e.Date.Round(time.Second)
@@ -58,6 +170,33 @@ func (es Entries) RenderHTML(w io.Writer) error {
 	})
 }
 
+// RenderHTML renders the page with a form to change the filters and links
+// to the surrounding pages.
+func (p Page) RenderHTML(w io.Writer, u *url.URL, q ListQuery) error {
+	params := u.Query()
+	return tmplEntries.Execute(w, map[string]interface{}{
+		"Entries":    p.Entries,
+		"Stylesheet": "entry.css",
+		"Filter": map[string]interface{}{
+			"From":  params.Get("from"),
+			"To":    params.Get("to"),
+			"Types": strings.Join(q.Types, ","),
+			"Tags":  strings.Join(q.Tags, ","),
+			"Limit": q.Limit,
+		},
+		"Next": pageURL(u, p.Next),
+		"Prev": pageURL(u, p.Prev),
+	})
+}
+
+func (es Entries) RenderTrashHTML(w io.Writer) error {
+	return tmplTrash.Execute(w, map[string]interface{}{
+		"Title":      "Trash - daily",
+		"Entries":    es,
+		"Stylesheet": "entry.css",
+	})
+}
+
 func (e Entry) RenderHTML(w io.Writer) error {
 	e.Date = e.Date.Round(time.Second)
 	return tmplEntry.Execute(w, map[string]interface{}{
@@ -84,30 +223,14 @@ func (vi VisualizeInfo) ToHTML(width, height int) template.HTML {
 	return template.HTML(buf.String())
 }
 
+// Visualize shows numEntries entries of a type as defined in its type
+// definition, undefined types are shown as one grey block per entry.
 func Visualize(typ string, numEntries int, sumValue float64) VisualizeInfo {
-	avgValue := sumValue / float64(numEntries)
-	switch typ {
-	case "coffee":
-		return VisualizeInfo{Color: "brown", Amount: sumValue}
-	case "water":
-		return VisualizeInfo{Color: "lightblue", Amount: sumValue}
-	case "throat":
-		// shade of green
-		//   0.0 == fine == rgb(255, 255, 255) == white
-		//   1.0 == bad  == rgb( 40, 203,   0) == awfulgreen
-		red := 255 - ((255 - 40) * avgValue)
-		green := 255 - ((255 - 203) * avgValue)
-		blue := 255 - ((255 - 0) * avgValue)
-		return VisualizeInfo{Color: fmt.Sprintf("rgb(%.1f, %.1f, %.1f)", red, green, blue), Amount: 1}
-	case "mood":
-		return VisualizeInfo{Color: "yellow", Amount: avgValue}
-	case "shower":
-		return VisualizeInfo{Color: "blue", Amount: sumValue / 10}
-	case "expense":
-		return VisualizeInfo{Color: "red", Amount: sumValue / 10}
-	default:
+	td, ok := entryTypes.Lookup(typ)
+	if !ok {
 		return VisualizeInfo{Color: "grey", Amount: float64(numEntries)}
 	}
+	return td.Visualize(numEntries, sumValue)
 }
 
 var entryFuncs = template.FuncMap{
@@ -120,11 +243,22 @@ var tmplEntryBase = template.Must(tmplBase.Funcs(entryFuncs).New("entry-base").P
 		<h1>{{ .Date }}</h1>
 		<span class="type">{{ .Type }}</span>
 		{{ (visualize .Type 1 .Value).ToHTML 16 16 }}
+		{{ if .DeletedAt }}
+		<span class="deleted">deleted {{ .DeletedAt }}</span>
+		{{ else }}
 		<a href="/{{ .ID }}/edit">/edit</a>
+		{{ end }}
+		<a href="/{{ .ID }}/history">/history</a>
 	</header>
 
 	<p>{{ .Note }}</p>
 
+	{{ with .Tags }}
+	<ul class="tags">
+		{{ range . }}<li><a href="/tags/{{ . }}">#{{ . }}</a></li>{{ end }}
+	</ul>
+	{{ end }}
+
 	<div class="data">
 		<pre>{{ .RenderJSONString }}</pre>
 	</div>
@@ -143,9 +277,46 @@ var tmplEntry = template.Must(tmplEntryBase.New("entry-single").Parse(`{{ templa
 
 var tmplEntries = template.Must(tmplEntryBase.New("entries").Parse(`{{ template "html-start" . }}
 <a href="/new">/new</a>
+<a href="/trash">/trash</a>
+<a href="/search">/search</a>
+<a href="/types">/types</a>
+<a href="/tags">/tags</a>
+
+{{ with .Filter }}
+<form method="GET" action="/" class="filter">
+	<input name="from" type="date" value="{{ .From }}" />
+	<input name="to" type="date" value="{{ .To }}" />
+	<input name="type" placeholder="type" value="{{ .Types }}" />
+	<input name="tag" placeholder="tag" value="{{ .Tags }}" />
+	<input name="limit" type="number" min="1" value="{{ .Limit }}" />
+	<input type="submit" value="Filter" />
+</form>
+{{ end }}
+
+{{ range .Entries }}
+	{{ template "entry" . }}
+{{ end }}
+
+<nav class="pagination">
+	{{ if .Prev }}<a href="{{ .Prev }}" rel="prev">previous</a>{{ end }}
+	{{ if .Next }}<a href="{{ .Next }}" rel="next">next</a>{{ end }}
+</nav>
+{{ template "html-end" }}
+`))
+
+var tmplTrash = template.Must(tmplEntryBase.New("trash").Parse(`{{ template "html-start" . }}
+<a href="/">/all</a>
 
 {{ range .Entries }}
 	{{ template "entry" . }}
+	<form method="POST" action="/{{ .ID }}/restore">
+		<input type="submit" value="Restore" />
+	</form>
+	<form method="POST" action="/{{ .ID }}/purge">
+		<input type="submit" value="Delete forever" />
+	</form>
+{{ else }}
+	<p>The trash is empty.</p>
 {{ end }}
 {{ template "html-end" }}
 `))
@@ -5,71 +5,77 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"strings"
 )
 
-func RenderInput(w http.ResponseWriter, req *http.Request, typeName string) {
-	data := map[string]interface{}{
-		"Title": "New entry - daily",
-		"Type":  typeName,
+// RenderInput renders the form for new entries, prefilled with entry.  If
+// errs is set the entry is shown again with its problems.
+func RenderInput(w http.ResponseWriter, req *http.Request, entry *Entry, errs ValidationError) {
+	td, ok := entryTypes.Lookup(entry.Type)
+	if !ok {
+		td = &TypeDefinition{Name: entry.Type}
 	}
-	tmpl := tmplInputDefault
+	fields, otherData := formFields(td, entry.Data)
 
-	if customTmpl, ok := inputTemplateFor[typeName]; ok {
-		tmpl = customTmpl.Template
-		for key, val := range customTmpl.Data {
-			data[key] = val
-		}
+	data := map[string]interface{}{
+		"Title":      "New entry - daily",
+		"Type":       entry.Type,
+		"Entry":      entry,
+		"Def":        td,
+		"Fields":     fields,
+		"Data":       otherData,
+		"Errors":     errs,
+		"Stylesheet": td.Stylesheet,
+		"Script":     td.Script,
+	}
+	if ok {
+		data["Title"] = entry.Type + " - daily"
 	}
 
-	err := tmpl.Execute(w, data)
+	if errs != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	err := tmplInputDefault.Execute(w, data)
 	if err != nil {
 		log.Println(err)
 		fmt.Fprint(w, err)
 	}
 }
 
-var inputTemplateFor = map[string]templateDefinition{
-	"mood": templateDefinition{
-		Template: tmplInputMood,
-		Data: map[string]interface{}{
-			"Title":      "mood - daily",
-			"Stylesheet": "mood.css",
-			"ValueLabel": "Mood",
-			"ValueType":  "range",
-			"ValueMin":   "0",
-			"ValueMax":   "1",
-			"ValueStep":  "0.01",
-		},
-	},
-}
-
-type templateDefinition struct {
-	Template *template.Template
-	Data     map[string]interface{}
-}
-
-var tmplInputMood = template.Must(tmplBase.New("input-mood").Parse(`
+var tmplInputDefault = template.Must(tmplBase.New("input-default").Parse(`
 {{- template "html-start" . }}
-	<div id="mood-gradient" class="hidden"></div>
+	{{ if not .Type }}
+	<form method="GET" action="/quick" class="quick-add">
+		<input name="text" placeholder="coffee 2 @08:30 #work oat milk" autocomplete="off" />
+		<input type="submit" value="Preview" />
+	</form>
+	{{ end }}
 
 	{{ template "input-form" . }}
-
-	<script defer async src="/static/mood.js"></script>
 {{ template "html-end" }}
 `))
 
-var tmplInputDefault = template.Must(tmplBase.New("input-default").Parse(`
-{{- template "html-start" . }}
-	{{ template "input-form" . }}
-{{ template "html-end" }}
-`))
+// RenderEdit renders the form for editing entry.  If errs is set the
+// entry is shown again with its problems.
+func RenderEdit(w http.ResponseWriter, req *http.Request, entry *Entry, types []string, errs ValidationError) {
+	td := entryTypes.Get(entry.Type)
+	fields, otherData := formFields(td, entry.Data)
 
-func RenderEdit(w http.ResponseWriter, req *http.Request, entry *Entry) {
 	data := map[string]interface{}{
-		"Title": "Edit entry - daily",
-		"Entry": entry,
+		"Title":      "Edit entry - daily",
+		"Entry":      entry,
+		"Types":      types,
+		"Def":        td,
+		"Fields":     fields,
+		"Data":       otherData,
+		"Errors":     errs,
+		"Stylesheet": td.Stylesheet,
+		"Script":     td.Script,
 	}
 
+	if errs != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
 	err := tmplEditDefault.Execute(w, data)
 	if err != nil {
 		log.Println(err)
@@ -77,6 +83,21 @@ func RenderEdit(w http.ResponseWriter, req *http.Request, entry *Entry) {
 	}
 }
 
+// formFields splits data into the declared fields, which get their own
+// inputs, and the rest, which is shown as key-value pairs.
+func formFields(td *TypeDefinition, data map[string]interface{}) ([]formField, map[string]interface{}) {
+	fields := make([]formField, len(td.Fields))
+	otherData := make(map[string]interface{}, len(data))
+	for key, val := range data {
+		otherData[key] = val
+	}
+	for i, field := range td.Fields {
+		fields[i] = formField{FieldDefinition: field, Value: otherData[field.Name]}
+		delete(otherData, field.Name)
+	}
+	return fields, otherData
+}
+
 var tmplEditDefault = template.Must(tmplBase.New("edit-default").Parse(`
 {{- template "html-start" . }}
 	{{ template "edit-form" . }}
@@ -88,59 +109,89 @@ var tmplBase = template.Must(template.New("base").Funcs(tmplFuncs).Parse(`{{ def
 		<h1>Create entry</h1>
 
 		<form method="POST" action="/new">
-			<input name="type" value="{{ .Type }}" placeholder="type" required {{ if .Type }}hidden{{ end }} />
+			{{ if and .Type (not .Errors.type) }}
+			<input name="type" value="{{ .Type }}" hidden />
+			{{ else }}
 			<div class="field">
-				<label for="value">{{ or .ValueLabel "Value" }}</label>
-				<input id="entry-value" name="value" type="{{ or .ValueType "number" }}" value="{{ or .ValueDefault "0" }}"
-					{{ if .ValueMin }}min="{{ .ValueMin }}" {{ end -}}
-					{{ if .ValueMax }}max="{{ .ValueMax }}" {{ end }}
-					step="{{ or .ValueStep "any" }}" />
+				<input name="type" value="{{ .Type }}" placeholder="type" required />
+				{{ template "field-error" .Errors.type }}
 			</div>
+			{{ end }}
 
-			<div class="field">
-				<label for="Note">Note</label>
-				<input name="note" type="text" />
-			</div>
+			{{ template "entry-fields" . }}
 
-			<h2>Additional data</h2>
+			<input type="submit" value="Save" />
+		</form>
+	</section>
+{{ end }}
+{{ define "edit-form" }}
+	<section id="content">
+		<h1>Edit entry</h1>
 
-			<div id="additional-fields"></div>
+		<form method="POST" action="/{{ .Entry.ID }}">
+			<input type="hidden" name="version" value="{{ .Entry.Version }}" />
+			<div class="field">
+				<label for="entry-date">Date (UTC)</label>
+				<input id="entry-date" name="date" type="datetime-local" step="1" required
+					value="{{ .Entry.Date.UTC.Format "2006-01-02T15:04:05" }}" />
+			</div>
 
 			<div class="field">
-				<button id="add-field">Add field</button>
+				<label for="entry-type">Type</label>
+				<input id="entry-type" name="type" value="{{ .Entry.Type }}" list="entry-types" required />
+				<datalist id="entry-types">
+					{{ range .Types }}<option value="{{ . }}" />{{ end }}
+				</datalist>
+				{{ template "field-error" .Errors.type }}
 			</div>
 
+			{{ template "entry-fields" . }}
+
 			<input type="submit" value="Save" />
 		</form>
+
+		<form method="POST" action="/{{ .Entry.ID }}/delete">
+			<input type="submit" value="Delete" />
+		</form>
 	</section>
 {{ end }}
-{{ define "edit-form" }}
-	<section id="content">
-		<h1>Edit entry</h1>
 
-		<form method="POST" action="/{{ .Entry.ID }}">
+{{ define "entry-fields" }}
 			<div class="field">
-				<input name="type" value="{{ .Entry.Type }}" disabled />
+				<label for="entry-value">{{ .Def.ValueLabel }}</label>
+				<input id="entry-value" name="value" type="{{ .Def.InputType }}" value="{{ .Entry.Value }}"
+					{{ template "value-attrs" .Def }} />
+				{{ template "field-error" .Errors.value }}
 			</div>
 
 			<div class="field">
-				<label for="value">{{ or .ValueLabel "Value" }}</label>
-				<input id="entry-value" name="value" type="{{ or .ValueType "number" }}" value="{{ .Entry.Value }}"
-					{{ if .ValueMin }}min="{{ .ValueMin }}" {{ end -}}
-					{{ if .ValueMax }}max="{{ .ValueMax }}" {{ end }}
-					step="{{ or .ValueStep "any" }}" />
+				<label for="entry-note">Note</label>
+				<input id="entry-note" name="note" type="text" value="{{ .Entry.Note }}"
+					{{ with .Def.MaxNoteLength }}maxlength="{{ . }}"{{ end }} />
+				{{ template "field-error" .Errors.note }}
+			</div>
 
+			<div class="field">
+				<label for="entry-tags">Tags</label>
+				<input id="entry-tags" name="tags" type="text" value="{{ join .Entry.Tags " " }}"
+					placeholder="work cafe" list="entry-tags-known" autocomplete="off" />
+				<datalist id="entry-tags-known"></datalist>
+				{{ template "field-error" .Errors.tags }}
 			</div>
+			<script defer src="{{ static "tags.js" }}"></script>
 
+			{{ range .Fields }}
 			<div class="field">
-				<label for="note">Note</label>
-				<input name="note" type="text" value="{{ .Entry.Note }}" />
+				<label for="field-{{ .Name }}">{{ .DisplayLabel }}</label>
+				{{ template "field-input" . }}
+				{{ template "field-error" ($.Errors.Data .Name) }}
 			</div>
+			{{ end }}
 
 			<h2>Additional data</h2>
 
 			<div id="additional-fields">
-			{{ range $key, $value := .Entry.Data }}
+			{{ range $key, $value := .Data }}
 				{{ if (isList $value) }}
 					{{ range $singleValue := $value }}
 					<div class="field">
@@ -160,12 +211,50 @@ var tmplBase = template.Must(template.New("base").Funcs(tmplFuncs).Parse(`{{ def
 			<div class="field">
 				<button id="add-field">Add field</button>
 			</div>
+{{ end }}
 
-			<input type="submit" value="Save" />
-		</form>
-	</section>
+{{ define "field-input" }}
+	{{ if eq .Type "boolean" }}
+	<input id="field-{{ .Name }}" name="{{ .Name }}" type="checkbox" value="true" {{ if .IsTrue }}checked{{ end }} />
+	{{ else if eq .Type "enum" }}
+	<select id="field-{{ .Name }}" name="{{ .Name }}" {{ if .Required }}required{{ end }}>
+		{{ if not .Required }}<option value=""></option>{{ end }}
+		{{ range .Enum }}<option {{ if $.Has . }}selected{{ end }}>{{ . }}</option>{{ end }}
+		{{ if and .Value (not (.Has .Text)) }}<option selected>{{ .Text }}</option>{{ end }}
+	</select>
+	{{ else if and (eq .Type "list") .Enum }}
+	<span id="field-{{ .Name }}">
+		{{ range .Enum }}
+		<label><input name="{{ $.Name }}" type="checkbox" value="{{ . }}" {{ if $.Has . }}checked{{ end }} /> {{ . }}</label>
+		{{ end }}
+	</span>
+	{{ else if eq .Type "" }}
+		{{ range .Values }}
+	<input id="field-{{ $.Name }}" name="{{ $.Name }}" type="text" value="{{ . }}" {{ template "field-attrs" $ }} />
+		{{ else }}
+	<input id="field-{{ .Name }}" name="{{ .Name }}" type="text" {{ template "field-attrs" . }} />
+		{{ end }}
+	{{ else }}
+	<input id="field-{{ .Name }}" name="{{ .Name }}" type="{{ .InputType }}" value="{{ .Text }}"
+		{{ with .Placeholder }}placeholder="{{ . }}" {{ end -}}
+		{{ if eq .Type "number" }}step="any" {{ end -}}
+		{{ template "field-attrs" . }} />
+	{{ end }}
 {{ end }}
 
+{{ define "field-attrs" -}}
+	{{ if .Required }}required {{ end -}}
+	{{ with .Pattern }}pattern="{{ . }}" {{ end -}}
+{{- end }}
+
+{{ define "field-error" }}{{ with . }}<p class="error">{{ . }}</p>{{ end }}{{ end }}
+
+{{ define "value-attrs" -}}
+	{{ with .Min }}min="{{ . }}" {{ end -}}
+	{{ with .Max }}max="{{ . }}" {{ end -}}
+	step="{{ with .Step }}{{ . }}{{ else }}any{{ end }}"
+{{- end }}
+
 {{ define "html-start" }}
 <!doctype html>
 <html>
@@ -173,12 +262,15 @@ var tmplBase = template.Must(template.New("base").Funcs(tmplFuncs).Parse(`{{ def
 	<title>{{ .Title }}</title>
 	<meta name="viewport" content="width=device-width, initial-scale=1" />
 
-	<link rel="stylesheet" href="/static/default.css" />
+	<link rel="stylesheet" href="{{ static "default.css" }}" />
 	{{ if .Stylesheet }}
-	<link rel="stylesheet" href="/static/{{ .Stylesheet }}" />
+	<link rel="stylesheet" href="{{ static .Stylesheet }}" />
 	{{ end }}
 
-	<script defer async src="/static/fields.js"></script>
+	<script defer async src="{{ static "fields.js" }}"></script>
+	{{ if .Script }}
+	<script defer src="{{ static .Script }}"></script>
+	{{ end }}
 </head>
 
 <body>
@@ -192,6 +284,10 @@ var tmplBase = template.Must(template.New("base").Funcs(tmplFuncs).Parse(`{{ def
 `))
 
 var tmplFuncs = template.FuncMap{
+	"join": strings.Join,
+	"static": func(name string) string {
+		return staticAssets.Path(name)
+	},
 	"isList": func(val interface{}) bool {
 		switch val.(type) {
 		case []interface{}:
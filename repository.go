@@ -6,20 +6,63 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
+	"sort"
 	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+func init() {
+	sql.Register("sqlite3_daily", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			err := conn.RegisterFunc("data_matches", dataMatchesSQL, true)
+			if err != nil {
+				return err
+			}
+			err = conn.RegisterFunc("data_tags", dataTags, true)
+			if err != nil {
+				return err
+			}
+			err = conn.RegisterFunc("data_without", dataWithout, true)
+			if err != nil {
+				return err
+			}
+			return conn.RegisterFunc("data_text", dataText, true)
+		},
+	})
+}
+
 type Repository interface {
 	Create(ctx context.Context, entry *Entry) (id string, err error)
+	Import(ctx context.Context, entries []Entry, dryRun bool) (duplicates []int, err error)
 	Get(ctx context.Context, id string) (*Entry, error)
 	Update(ctx context.Context, entry *Entry) error
-	Query(ctx context.Context, query string) (Entries, error)
+	Modify(ctx context.Context, id string, modify func(entry *Entry) error) (*Entry, error)
+	Delete(ctx context.Context, id string) error
+	Restore(ctx context.Context, id string) error
+	Purge(ctx context.Context, id string) error
+	History(ctx context.Context, id string) ([]Revision, error)
+	Revert(ctx context.Context, id string, revision int64, version int64) error
+	QueryTable(ctx context.Context, query string, args ...interface{}) (*Table, error)
 	FindBetween(ctx context.Context, dateStart, dateEnd time.Time, order order) (Entries, error)
+	FindDeleted(ctx context.Context) (Entries, error)
+	List(ctx context.Context, q ListQuery) (Page, error)
+	Search(ctx context.Context, q SearchQuery) ([]SearchResult, error)
+	Find(ctx context.Context, f Filter) (Entries, error)
+	Each(ctx context.Context, f Filter, keys func(keys []string) error, fn func(entry *Entry) error) error
+	Types(ctx context.Context) ([]string, error)
+	TypeStats(ctx context.Context) ([]TypeStats, error)
+	Tags(ctx context.Context) ([]TagStats, error)
+	RenameType(ctx context.Context, from, to string, merge bool) (int64, error)
+
+	SaveQuery(ctx context.Context, query *SavedQuery) error
+	SavedQuery(ctx context.Context, name string) (*SavedQuery, error)
+	SavedQueries(ctx context.Context) ([]SavedQuery, error)
+	DeleteSavedQuery(ctx context.Context, name string) error
 }
 
 type order int
@@ -40,43 +83,116 @@ func (o order) String() string {
 	}
 }
 
-func NewRepository(dbFileName string, schemaFileName string) (Repository, error) {
-	db, err := sql.Open("sqlite3", dbFileName)
+// Reverse returns the opposite order.
+func (o order) Reverse() order {
+	if o == Ascending {
+		return Descending
+	}
+	return Ascending
+}
+
+// NewRepository opens the database and applies pending migrations from the
+// given file system.
+func NewRepository(dbFileName string, migrations fs.FS) (Repository, error) {
+	db, err := sql.Open("sqlite3_daily", dbFileName)
 	if err != nil {
 		return nil, fmt.Errorf("could not open db in %q: %s", dbFileName, err)
 	}
 
-	err = initSchema(context.Background(), db, schemaFileName)
+	ms, err := loadMigrations(migrations)
 	if err != nil {
-		return nil, fmt.Errorf("could not initialize schema: %s", err)
+		return nil, fmt.Errorf("could not load migrations: %s", err)
 	}
 
-	return &repository{db: db}, nil
-}
+	// the search index of databases created by a binary with FTS5 can't
+	// be changed without it, which would break every write
+	fts5, err := searchUsesFTS5(db)
+	if err != nil {
+		return nil, err
+	}
+	if fts5 && !hasFTS5 {
+		return nil, fmt.Errorf("the search index in %q uses FTS5, build with `go build -tags sqlite_fts5`", dbFileName)
+	}
 
-func initSchema(ctx context.Context, db *sql.DB, schemaFileName string) error {
-	schemaSQL, err := ioutil.ReadFile(schemaFileName)
+	err = migrate(context.Background(), db, ms)
 	if err != nil {
-		return fmt.Errorf("could not read schema sql from %q: %s", schemaFileName, err)
+		return nil, fmt.Errorf("could not migrate schema: %s", err)
 	}
 
-	statements := strings.Split(string(schemaSQL), ";")
-	for _, stmt := range statements {
-		_, err := db.ExecContext(ctx, stmt)
+	fts5, err = searchUsesFTS5(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// hand-written queries run on a separate connection that can't
+	// change anything.  in-memory databases can't be shared like that,
+	// there QueryTable only relies on the query_only pragma.
+	readOnly := db
+	if dbFileName != ":memory:" {
+		readOnly, err = sql.Open("sqlite3_daily", readOnlyDSN(dbFileName))
 		if err != nil {
-			return fmt.Errorf("could not execute %q: %s", stmt, err)
+			return nil, fmt.Errorf("could not open db in %q read-only: %s", dbFileName, err)
 		}
 	}
 
-	return nil
+	return &repository{db: db, readOnly: readOnly, fts5: fts5}, nil
+}
+
+// searchUsesFTS5 reports whether the search index is an FTS5 table, older
+// databases and binaries built without FTS5 use FTS4.
+func searchUsesFTS5(db *sql.DB) (bool, error) {
+	var tableSQL string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'entries_search'").Scan(&tableSQL)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check search index: %s", err)
+	}
+	return strings.Contains(strings.ToLower(tableSQL), "using fts5"), nil
+}
+
+func readOnlyDSN(dbFileName string) string {
+	dsn := dbFileName
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&mode=ro&_query_only=true"
+	}
+	return dsn + "?mode=ro&_query_only=true"
 }
 
 type repository struct {
-	db *sql.DB
+	db       *sql.DB
+	readOnly *sql.DB
+
+	// fts5 is set if the search index uses FTS5 instead of FTS4, which
+	// take their arguments to snippet in a different order.
+	fts5 bool
 }
 
 func (r *repository) Create(ctx context.Context, entry *Entry) (id string, err error) {
-	id, err = generateID()
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	id, err = insert(ctx, tx, entry)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", fmt.Errorf("could not commit: %s", err)
+	}
+	return id, nil
+}
+
+func insert(ctx context.Context, tx *sql.Tx, entry *Entry) (string, error) {
+	id, err := generateID()
 	if err != nil {
 		return "", fmt.Errorf("could not generate id: %s", err)
 	}
@@ -86,15 +202,77 @@ func (r *repository) Create(ctx context.Context, entry *Entry) (id string, err e
 		return "", fmt.Errorf("could not serialize additional data: %s", err)
 	}
 
-	_, err = r.db.ExecContext(ctx, "INSERT INTO entries (id, date, type, note, value, data) VALUES (?, ?, ?, ?, ?, ?)",
-		id, entry.Date, entry.Type, entry.Note, entry.Value, dataJSON)
+	_, err = tx.ExecContext(ctx, "INSERT INTO entries (id, date, type, note, value, data) VALUES (?, ?, ?, ?, ?, ?)",
+		id, entry.Date.UTC(), entry.Type, entry.Note, entry.Value, dataJSON)
 	if err != nil {
 		return "", fmt.Errorf("could not store entry: %s", err)
 	}
 
+	entry.Tags = entryTags(entry)
+	err = setTags(ctx, tx, id, entry.Tags)
+	if err != nil {
+		return "", err
+	}
+
+	err = reindex(ctx, tx, id)
+	if err != nil {
+		return "", err
+	}
+
 	return id, nil
 }
 
+// Import stores all entries in one transaction, except for duplicates of
+// existing entries or of earlier entries in the list.  Entries are
+// duplicates if they have the same date, type, value and note.  It
+// returns the indexes of the duplicates that were skipped.  With dryRun,
+// nothing is stored.
+func (r *repository) Import(ctx context.Context, entries []Entry, dryRun bool) (duplicates []int, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	duplicates = make([]int, 0, 10)
+	for i := range entries {
+		entry := &entries[i]
+
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM entries
+		                                 WHERE date = ? AND type = ? AND value = ? AND note = ? AND deleted_at IS NULL)`,
+			entry.Date.UTC(), entry.Type, entry.Value, entry.Note).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("could not check for duplicates: %s", err)
+		}
+		if exists {
+			duplicates = append(duplicates, i)
+			continue
+		}
+
+		// inserting in dry runs too finds duplicates within the import
+		entry.ID, err = insert(ctx, tx, entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", i, err)
+		}
+		entry.Version = 1
+	}
+
+	if dryRun {
+		for i := range entries {
+			entries[i].ID = ""
+			entries[i].Version = 0
+		}
+		return duplicates, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("could not commit: %s", err)
+	}
+	return duplicates, nil
+}
+
 func generateID() (string, error) {
 	buf := make([]byte, 12)
 	_, err := rand.Read(buf)
@@ -106,7 +284,7 @@ func generateID() (string, error) {
 
 func (r *repository) Get(ctx context.Context, id string) (*Entry, error) {
 	var entry Entry
-	row := r.db.QueryRowContext(ctx, "SELECT id, date, type, note, value, data FROM entries WHERE id = ?", id)
+	row := r.db.QueryRowContext(ctx, "SELECT "+entryColumns+" FROM entries WHERE id = ?", id)
 	err := scanEntry(row, &entry)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -119,18 +297,316 @@ func (r *repository) Get(ctx context.Context, id string) (*Entry, error) {
 	return &entry, nil
 }
 
+// ErrConflict is returned when updating an entry that has been changed
+// since it was read.
+var ErrConflict = errors.New("entry was changed concurrently")
+
+// ErrNotFound is returned when updating an entry that doesn't exist or
+// is in the trash.
+var ErrNotFound = errors.New("no such entry")
+
+// ErrNoRevision is returned when reverting to a revision the entry
+// doesn't have.
+var ErrNoRevision = errors.New("no such revision")
+
+// Update replaces the entry with the same id, after recording its
+// previous version in the history.  The entry must have the version that
+// is currently stored, otherwise ErrConflict is returned.  Entries in the
+// trash can't be updated, that returns ErrNotFound.
 func (r *repository) Update(ctx context.Context, entry *Entry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	err = update(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Modify changes the entry with the given id using modify, reading and
+// writing it in one transaction so that concurrent changes aren't lost.
+// It returns nil if there is no such entry.
+func (r *repository) Modify(ctx context.Context, id string, modify func(entry *Entry) error) (*Entry, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	var entry Entry
+	row := tx.QueryRowContext(ctx, "SELECT "+entryColumns+" FROM entries WHERE id = ? AND deleted_at IS NULL", id)
+	err = scanEntry(row, &entry)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not get entry with id %q: %s", id, err)
+	}
+
+	err = modify(&entry)
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = id
+
+	err = update(ctx, tx, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("could not commit: %s", err)
+	}
+	return &entry, nil
+}
+
+func update(ctx context.Context, tx *sql.Tx, entry *Entry) error {
 	dataJSON, err := json.Marshal(entry.Data)
 	if err != nil {
 		return fmt.Errorf("could not serialize additional data: %s", err)
 	}
 
-	res, err := r.db.ExecContext(ctx, "UPDATE entries SET type = ?, note = ?, value = ?, data = ? WHERE id = ?",
-		entry.Type, entry.Note, entry.Value, dataJSON, entry.ID)
+	_, err = tx.ExecContext(ctx, `INSERT INTO entry_revisions (entry_id, revised_at, date, type, note, value, data, tags)
+	                              SELECT id, ?, date, type, note, value, data, `+tagsColumn+`
+				        FROM entries
+				       WHERE id = ?`, time.Now().UTC(), entry.ID)
+	if err != nil {
+		return fmt.Errorf("could not record revision: %s", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE entries
+	                                    SET date = ?, type = ?, note = ?, value = ?, data = ?, version = version + 1
+					  WHERE id = ? AND version = ? AND deleted_at IS NULL`,
+		entry.Date.UTC(), entry.Type, entry.Note, entry.Value, dataJSON, entry.ID, entry.Version)
 	if err != nil {
 		return fmt.Errorf("could not update entry: %s", err)
 	}
 
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get affected rows: %s", err)
+	}
+	if numRows != 1 {
+		var deleted bool
+		err = tx.QueryRowContext(ctx, "SELECT deleted_at IS NOT NULL FROM entries WHERE id = ?", entry.ID).Scan(&deleted)
+		if err == sql.ErrNoRows || deleted {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("could not check entry: %s", err)
+		}
+		return ErrConflict
+	}
+
+	tags := entryTags(entry)
+	err = setTags(ctx, tx, entry.ID, tags)
+	if err != nil {
+		return err
+	}
+
+	err = reindex(ctx, tx, entry.ID)
+	if err != nil {
+		return err
+	}
+
+	entry.Tags = tags
+	entry.Version++
+	return nil
+}
+
+// setTags replaces the tags of the entry with the given id.
+func setTags(ctx context.Context, tx execer, id string, tags []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM entry_tags WHERE entry_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("could not remove tags: %s", err)
+	}
+
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, "INSERT INTO entry_tags (entry_id, tag) VALUES (?, ?)", id, tag)
+		if err != nil {
+			return fmt.Errorf("could not store tag %q: %s", tag, err)
+		}
+	}
+	return nil
+}
+
+// searchDataColumn selects the text in the additional data and the tags of
+// the entry, which are searched together.
+const searchDataColumn = "trim(data_text(data) || ' ' || ifnull(replace(" + tagsColumn + ", char(31), ' '), ''))"
+
+// reindex updates the search index for the entry with the given id.
+// Deleted entries are removed from it, so that they can't be found.
+func reindex(ctx context.Context, tx execer, id string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM entries_search WHERE entry_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("could not remove entry from search index: %s", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO entries_search (entry_id, note, data)
+	                              SELECT id, note, `+searchDataColumn+`
+				        FROM entries
+				       WHERE id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("could not add entry to search index: %s", err)
+	}
+	return nil
+}
+
+// History returns the previous versions of the entry, oldest first.
+func (r *repository) History(ctx context.Context, id string) ([]Revision, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, revised_at, entry_id, date, type, note, value, data, tags
+	                                       FROM entry_revisions
+					      WHERE entry_id = ?
+					   ORDER BY id ASC`, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	revisions := make([]Revision, 0, 10)
+	for rows.Next() {
+		var revision Revision
+		err := scanRevision(rows, &revision)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan revision: %s", err)
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not finish query: %s", err)
+	}
+
+	return revisions, nil
+}
+
+// Revert restores the entry to the given revision.  The version being
+// replaced is kept in the history, so reverting can be undone as well.
+// Like Update, version must be the currently stored version of the entry,
+// otherwise ErrConflict is returned.
+func (r *repository) Revert(ctx context.Context, id string, revisionID int64, version int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	var revision Revision
+	row := tx.QueryRowContext(ctx, `SELECT id, revised_at, entry_id, date, type, note, value, data, tags
+	                                  FROM entry_revisions
+					 WHERE id = ? AND entry_id = ?`, revisionID, id)
+	err = scanRevision(row, &revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoRevision
+		}
+		return fmt.Errorf("could not get revision: %s", err)
+	}
+
+	revision.Entry.Version = version
+	err = update(ctx, tx, &revision.Entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanRevision(scanner scanner, revision *Revision) error {
+	var rawData []byte
+	var tags sql.NullString
+	err := scanner.Scan(&revision.ID, &revision.RevisedAt,
+		&revision.Entry.ID, &revision.Entry.Date, &revision.Entry.Type, &revision.Entry.Note, &revision.Entry.Value, &rawData,
+		&tags)
+	if err != nil {
+		return err
+	}
+	revision.Entry.Tags = splitTags(tags.String)
+
+	return unmarshalData(rawData, &revision.Entry)
+}
+
+// Delete moves the entry to the trash, from where it can either be
+// restored or purged.
+func (r *repository) Delete(ctx context.Context, id string) error {
+	return r.execAndReindex(ctx, id, "UPDATE entries SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL",
+		time.Now().UTC(), id)
+}
+
+func (r *repository) Restore(ctx context.Context, id string) error {
+	return r.execAndReindex(ctx, id, "UPDATE entries SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL", id)
+}
+
+// execAndReindex changes a single entry and updates its search index in
+// one transaction.
+func (r *repository) execAndReindex(ctx context.Context, id string, query string, args ...interface{}) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	err = execSingle(ctx, tx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	err = reindex(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Purge removes a deleted entry and its history for good.
+func (r *repository) Purge(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	err = execSingle(ctx, tx, "DELETE FROM entries WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM entry_revisions WHERE entry_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("could not delete history: %s", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM entry_tags WHERE entry_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("could not delete tags: %s", err)
+	}
+
+	err = reindex(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execer abstracts ExecContext() over both sql.DB and sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// execSingle executes a statement that is expected to change exactly one row.
+func execSingle(ctx context.Context, db execer, query string, args ...interface{}) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("could not execute: %s", err)
+	}
+
 	numRows, err := res.RowsAffected()
 	if err != nil {
 		return fmt.Errorf("could not get affected rows: %s", err)
@@ -147,16 +623,26 @@ type scanner interface {
 	Scan(dest ...interface{}) error
 }
 
+// entryColumns are the columns scanEntry expects, in order.
+const entryColumns = "id, date, type, note, value, data, deleted_at, version, " + tagsColumn + " AS tags"
+
 func scanEntry(scanner scanner, entry *Entry) error {
 	var rawData []byte
-	err := scanner.Scan(&entry.ID, &entry.Date, &entry.Type, &entry.Note, &entry.Value, &rawData)
+	var tags sql.NullString
+	err := scanner.Scan(&entry.ID, &entry.Date, &entry.Type, &entry.Note, &entry.Value, &rawData,
+		&entry.DeletedAt, &entry.Version, &tags)
 	if err != nil {
 		return err
 	}
+	entry.Tags = splitTags(tags.String)
 
+	return unmarshalData(rawData, entry)
+}
+
+func unmarshalData(rawData []byte, entry *Entry) error {
 	if len(rawData) > 0 {
 		var data map[string]interface{}
-		err = json.Unmarshal(rawData, &data)
+		err := json.Unmarshal(rawData, &data)
 		if err != nil {
 			return fmt.Errorf("additional data %q was invalid: %s", string(rawData), err)
 		}
@@ -165,53 +651,659 @@ func scanEntry(scanner scanner, entry *Entry) error {
 	return nil
 }
 
-func (r *repository) Query(ctx context.Context, query string) (Entries, error) {
-	rows, err := r.db.QueryContext(ctx, query)
+// QueryTable executes arbitrary SQL on a read-only connection.  Long
+// running queries can be stopped by cancelling ctx.
+func (r *repository) QueryTable(ctx context.Context, query string, args ...interface{}) (*Table, error) {
+	conn, err := r.readOnly.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get connection: %s", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "PRAGMA query_only = 1")
+	if err != nil {
+		return nil, fmt.Errorf("could not make connection read-only: %s", err)
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA query_only = 0")
+
+	rows, err := conn.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("could not execute query: %s", err)
 	}
 	defer rows.Close()
 
-	entries := make([]Entry, 0, 100)
+	return scanTable(rows)
+}
+
+func (r *repository) FindBetween(ctx context.Context, dateStart, dateEnd time.Time, order order) (Entries, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+entryColumns+`
+	                          FROM entries
+				 WHERE date >= ?
+				   AND date <= ?
+				   AND deleted_at IS NULL
+				ORDER BY date `+order.String(), dateStart, dateEnd)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	return scanEntries(rows)
+}
+
+// List returns a page of entries that are not in the trash.
+func (r *repository) List(ctx context.Context, q ListQuery) (Page, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	args := []interface{}{}
+
+	if !q.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, q.To.UTC())
+	}
+	if len(q.Types) > 0 {
+		conditions = append(conditions, "type IN (?"+strings.Repeat(", ?", len(q.Types)-1)+")")
+		for _, typ := range q.Types {
+			args = append(args, typ)
+		}
+	}
+	for _, tag := range q.Tags {
+		conditions = append(conditions, "id IN (SELECT entry_id FROM entry_tags WHERE tag = ?)")
+		args = append(args, tag)
+	}
+
+	// going backward means scanning in the opposite order and reversing
+	// the result afterwards
+	backward := q.Cursor != nil && q.Cursor.Backward
+	scanOrder := q.Order
+	if backward {
+		scanOrder = q.Order.Reverse()
+	}
+	if q.Cursor != nil {
+		cmp := ">"
+		if scanOrder == Descending {
+			cmp = "<"
+		}
+		conditions = append(conditions, "(date, id) "+cmp+" (?, ?)")
+		args = append(args, q.Cursor.Date.UTC(), q.Cursor.ID)
+	}
+
+	args = append(args, q.Limit+1)
+	rows, err := r.db.QueryContext(ctx, `SELECT `+entryColumns+`
+	                                       FROM entries
+					      WHERE `+strings.Join(conditions, " AND ")+`
+					   ORDER BY date `+scanOrder.String()+`, id `+scanOrder.String()+`
+					      LIMIT ?`, args...)
+	if err != nil {
+		return Page{}, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	entries, err := scanEntries(rows)
+	if err != nil {
+		return Page{}, err
+	}
+
+	more := len(entries) > q.Limit
+	if more {
+		entries = entries[:q.Limit]
+	}
+	if backward {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	page := Page{Entries: entries}
+	if len(entries) == 0 {
+		return page, nil
+	}
+
+	first, last := entries[0], entries[len(entries)-1]
+	if (!backward && more) || (backward && q.Cursor != nil) {
+		page.Next = &Cursor{Date: last.Date, ID: last.ID}
+	}
+	if (backward && more) || (!backward && q.Cursor != nil) {
+		page.Prev = &Cursor{Date: first.Date, ID: first.ID, Backward: true}
+	}
+	return page, nil
+}
+
+// Find returns the entries matching the filter.
+func (r *repository) Find(ctx context.Context, f Filter) (Entries, error) {
+	return r.findAfter(ctx, f, nil)
+}
+
+// findAfter returns the entries matching the filter that come after the
+// cursor in the order of the filter, or from the start if it is nil.
+func (r *repository) findAfter(ctx context.Context, f Filter, after *Cursor) (Entries, error) {
+	query, args := filterQuery(entryColumns, f, after)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	return scanEntries(rows)
+}
+
+// eachPageSize is the number of entries Each reads at once.
+var eachPageSize = 500
+
+// Each calls fn for every entry matching the filter, without keeping all
+// of them in memory.  If keys is not nil, it is called first with the
+// sorted keys of the additional data of all those entries, e.g. to write
+// a header.
+//
+// The entries are read a page at a time, so that slow consumers like
+// exports over the network don't keep the database busy.  Entries changed
+// in the meantime may be seen in their new state.
+func (r *repository) Each(ctx context.Context, f Filter, keys func(keys []string) error, fn func(entry *Entry) error) error {
+	if keys != nil {
+		dataKeys, err := eachDataKeys(ctx, r.db, f)
+		if err != nil {
+			return err
+		}
+		err = keys(dataKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	var after *Cursor
+	remaining := f.Limit
+	for {
+		page := f
+		page.Limit = eachPageSize
+		if f.Limit > 0 && remaining < eachPageSize {
+			page.Limit = remaining
+		}
+
+		entries, err := r.findAfter(ctx, page, after)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			err = fn(&entries[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		remaining -= len(entries)
+		if len(entries) < page.Limit || (f.Limit > 0 && remaining <= 0) {
+			return nil
+		}
+		last := entries[len(entries)-1]
+		after = &Cursor{Date: last.Date, ID: last.ID}
+	}
+}
+
+func eachDataKeys(ctx context.Context, db *sql.DB, f Filter) ([]string, error) {
+	query, args := filterQuery("data", f, nil)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
 	for rows.Next() {
 		var entry Entry
-		err = scanEntry(rows, &entry)
+		var rawData []byte
+		err := rows.Scan(&rawData)
 		if err != nil {
-			return nil, fmt.Errorf("could not scan entry: %s", err)
+			return nil, fmt.Errorf("could not scan data: %s", err)
+		}
+		err = unmarshalData(rawData, &entry)
+		if err != nil {
+			return nil, err
+		}
+		for key := range entry.Data {
+			seen[key] = true
 		}
-		entries = append(entries, entry)
 	}
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("could not finish query: %s", err)
 	}
 
-	return entries, nil
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// filterQuery returns SQL selecting the given columns of the entries
+// matching the filter that come after the cursor, if it is not nil, and
+// the arguments for it.
+func filterQuery(columns string, f Filter, after *Cursor) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if !f.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if len(f.Types) > 0 {
+		conditions = append(conditions, "type IN (?"+strings.Repeat(", ?", len(f.Types)-1)+")")
+		for _, typ := range f.Types {
+			args = append(args, typ)
+		}
+	}
+	for _, tag := range f.Tags {
+		conditions = append(conditions, "id IN (SELECT entry_id FROM entry_tags WHERE tag = ?)")
+		args = append(args, tag)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, f.To.UTC())
+	}
+	if f.MinValue != nil {
+		conditions = append(conditions, "value >= ?")
+		args = append(args, *f.MinValue)
+	}
+	if f.MaxValue != nil {
+		conditions = append(conditions, "value <= ?")
+		args = append(args, *f.MaxValue)
+	}
+	if f.NoteContains != "" {
+		conditions = append(conditions, `note LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(f.NoteContains)+"%")
+	}
+	for _, predicate := range f.Data {
+		conditions = append(conditions, "data_matches(data, ?, ?, ?)")
+		args = append(args, predicate.Key, predicate.Op, predicate.Value)
+	}
+	if after != nil {
+		cmp := ">"
+		if f.Order == Descending {
+			cmp = "<"
+		}
+		conditions = append(conditions, "(date, id) "+cmp+" (?, ?)")
+		args = append(args, after.Date.UTC(), after.ID)
+	}
 
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := ""
+	if f.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	return `SELECT ` + columns + `
+	          FROM entries
+	       ` + where + `
+	      ORDER BY date ` + f.Order.String() + `, id ` + f.Order.String() + `
+	       ` + limit, args
 }
 
-func (r *repository) FindBetween(ctx context.Context, dateStart, dateEnd time.Time, order order) (Entries, error) {
-	rows, err := r.db.QueryContext(ctx, `SELECT id, date, type, note, value, data
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Search finds entries with notes or additional data matching the query,
+// newest first.
+func (r *repository) Search(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	snippet := "snippet(entries_search, ?, ?, '…', -1, 16)"
+	if r.fts5 {
+		snippet = "snippet(entries_search, -1, ?, ?, '…', 16)"
+	}
+	query := `SELECT e.id, e.date, e.type, e.note, e.value, e.data, e.deleted_at, e.version,
+	                 (SELECT group_concat(tag, char(31)) FROM entry_tags WHERE entry_id = e.id),
+	                 ` + snippet + `
+	            FROM entries_search s
+	            JOIN entries e ON e.id = s.entry_id
+	           WHERE entries_search MATCH ? AND e.deleted_at IS NULL`
+	args := []interface{}{snippetStart, snippetEnd, q.Text}
+	if len(q.Types) > 0 {
+		query += " AND e.type IN (?" + strings.Repeat(", ?", len(q.Types)-1) + ")"
+		for _, typ := range q.Types {
+			args = append(args, typ)
+		}
+	}
+	query += " ORDER BY e.date DESC, e.id DESC LIMIT ?"
+	args = append(args, q.Limit)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, r.searchError(ctx, q.Text, err)
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0, 10)
+	for rows.Next() {
+		var result SearchResult
+		var rawData []byte
+		var tags sql.NullString
+		var snippet string
+		err := rows.Scan(&result.Entry.ID, &result.Entry.Date, &result.Entry.Type, &result.Entry.Note, &result.Entry.Value, &rawData,
+			&result.Entry.DeletedAt, &result.Entry.Version, &tags, &snippet)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan search result: %s", err)
+		}
+		result.Entry.Tags = splitTags(tags.String)
+		err = unmarshalData(rawData, &result.Entry)
+		if err != nil {
+			return nil, err
+		}
+		result.Snippet = highlightSnippet(snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, r.searchError(ctx, q.Text, err)
+	}
+
+	return results, nil
+}
+
+// searchError distinguishes invalid search syntax from other errors.
+// SQLite reports both as generic errors, so the search text is tried on
+// its own against the index, next to a query that is known to be valid.
+func (r *repository) searchError(ctx context.Context, text string, err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrError && r.invalidMatch(ctx, text) {
+		return fmt.Errorf("%w: %s", ErrInvalidSearch, err)
+	}
+	return fmt.Errorf("could not execute search: %s", err)
+}
+
+// invalidMatch reports whether text is rejected as a full-text query,
+// while the index itself can be searched.
+func (r *repository) invalidMatch(ctx context.Context, text string) bool {
+	const query = "SELECT rowid FROM entries_search WHERE entries_search MATCH ? LIMIT 1"
+	var rowid int64
+	err := r.db.QueryRowContext(ctx, query, `"daily"`).Scan(&rowid)
+	if err != nil && err != sql.ErrNoRows {
+		return false
+	}
+	err = r.db.QueryRowContext(ctx, query, text).Scan(&rowid)
+	return err != nil && err != sql.ErrNoRows
+}
+
+// FindDeleted returns all entries in the trash, most recently deleted first.
+func (r *repository) FindDeleted(ctx context.Context) (Entries, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+entryColumns+`
 	                          FROM entries
-				 WHERE date >= ?
-				   AND date <= ?
-				ORDER BY date `+order.String(), dateStart, dateEnd)
+				 WHERE deleted_at IS NOT NULL
+				ORDER BY deleted_at DESC`)
 	if err != nil {
 		return nil, fmt.Errorf("could not execute query: %s", err)
 	}
 	defer rows.Close()
 
+	return scanEntries(rows)
+}
+
+func scanEntries(rows *sql.Rows) (Entries, error) {
 	entries := make([]Entry, 0, 100)
 	for rows.Next() {
 		var entry Entry
-		err = scanEntry(rows, &entry)
+		err := scanEntry(rows, &entry)
 		if err != nil {
 			return nil, fmt.Errorf("could not scan entry: %s", err)
 		}
 		entries = append(entries, entry)
 	}
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("could not finish query: %s", err)
 	}
 
 	return entries, nil
 }
+
+// Types returns the types of all entries that are not in the trash.
+func (r *repository) Types(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT DISTINCT type FROM entries WHERE deleted_at IS NULL ORDER BY type")
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	types := make([]string, 0, 10)
+	for rows.Next() {
+		var typ string
+		err := rows.Scan(&typ)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan type: %s", err)
+		}
+		types = append(types, typ)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not finish query: %s", err)
+	}
+
+	return types, nil
+}
+
+// TypeStats describes how a type is used.
+type TypeStats struct {
+	Type     string    `json:"type"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// TypeStats returns the number of entries and the date of the latest one
+// for all types of entries that are not in the trash.
+func (r *repository) TypeStats(ctx context.Context) ([]TypeStats, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT type, count(*), max(date)
+	                                       FROM entries
+					      WHERE deleted_at IS NULL
+					   GROUP BY type
+					   ORDER BY type`)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	stats := make([]TypeStats, 0, 10)
+	for rows.Next() {
+		var ts TypeStats
+		var lastUsed string
+		err := rows.Scan(&ts.Type, &ts.Count, &lastUsed)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan type: %s", err)
+		}
+
+		// the result of max() has no declared type, so the date
+		// comes back as text
+		ts.LastUsed, err = parseTimestamp(lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, ts)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not finish query: %s", err)
+	}
+
+	return stats, nil
+}
+
+// TagStats describes how often a tag is used.
+type TagStats struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Tags returns all tags of entries that are not in the trash, the most
+// used ones first.
+func (r *repository) Tags(ctx context.Context) ([]TagStats, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT t.tag, count(*)
+	                                       FROM entry_tags t
+					       JOIN entries e ON e.id = t.entry_id
+					      WHERE e.deleted_at IS NULL
+					   GROUP BY t.tag
+					   ORDER BY count(*) DESC, t.tag`)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	tags := make([]TagStats, 0, 10)
+	for rows.Next() {
+		var ts TagStats
+		err := rows.Scan(&ts.Tag, &ts.Count)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan tag: %s", err)
+		}
+		tags = append(tags, ts)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not finish query: %s", err)
+	}
+
+	return tags, nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		t, err := time.ParseInLocation(format, s, time.UTC)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse timestamp %q", s)
+}
+
+// ErrTypeExists is returned when renaming a type to one that is used
+// already.
+var ErrTypeExists = errors.New("type exists already")
+
+// RenameType changes the type of all entries of type from to to,
+// including the ones in the trash, and returns how many were changed.
+// Unless merge is set there must not be any entries of type to yet.
+func (r *repository) RenameType(ctx context.Context, from, to string, merge bool) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if !merge {
+		var exists bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM entries WHERE type = ?)", to).Scan(&exists)
+		if err != nil {
+			return 0, fmt.Errorf("could not check type: %s", err)
+		}
+		if exists {
+			return 0, ErrTypeExists
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO entry_revisions (entry_id, revised_at, date, type, note, value, data, tags)
+	                              SELECT id, ?, date, type, note, value, data, `+tagsColumn+`
+				        FROM entries
+				       WHERE type = ?`, time.Now().UTC(), from)
+	if err != nil {
+		return 0, fmt.Errorf("could not record revisions: %s", err)
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE entries SET type = ?, version = version + 1 WHERE type = ?", to, from)
+	if err != nil {
+		return 0, fmt.Errorf("could not rename type: %s", err)
+	}
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not get affected rows: %s", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("could not commit: %s", err)
+	}
+	return numRows, nil
+}
+
+// SaveQuery stores the query, replacing any query with the same name.
+func (r *repository) SaveQuery(ctx context.Context, query *SavedQuery) error {
+	params, err := json.Marshal(query.Params)
+	if err != nil {
+		return fmt.Errorf("could not serialize params: %s", err)
+	}
+
+	query.UpdatedAt = time.Now().UTC()
+	_, err = r.db.ExecContext(ctx, `INSERT INTO saved_queries (name, description, query, params, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE
+		SET description = excluded.description, query = excluded.query, params = excluded.params, updated_at = excluded.updated_at`,
+		query.Name, query.Description, query.Query, string(params), query.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("could not save query: %s", err)
+	}
+	return nil
+}
+
+const savedQueryColumns = "name, description, query, params, updated_at"
+
+func scanSavedQuery(row scanner, query *SavedQuery) error {
+	var params string
+	err := row.Scan(&query.Name, &query.Description, &query.Query, &params, &query.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal([]byte(params), &query.Params)
+	if err != nil {
+		return fmt.Errorf("invalid params: %s", err)
+	}
+	return nil
+}
+
+// SavedQuery returns the query with the given name, or nil if there is
+// none.
+func (r *repository) SavedQuery(ctx context.Context, name string) (*SavedQuery, error) {
+	var query SavedQuery
+	row := r.db.QueryRowContext(ctx, "SELECT "+savedQueryColumns+" FROM saved_queries WHERE name = ?", name)
+	err := scanSavedQuery(row, &query)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("could not get saved query %q: %s", name, err)
+	}
+	return &query, nil
+}
+
+func (r *repository) SavedQueries(ctx context.Context) ([]SavedQuery, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+savedQueryColumns+" FROM saved_queries ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("could not execute query: %s", err)
+	}
+	defer rows.Close()
+
+	queries := make([]SavedQuery, 0, 10)
+	for rows.Next() {
+		var query SavedQuery
+		err := scanSavedQuery(rows, &query)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan saved query: %s", err)
+		}
+		queries = append(queries, query)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not finish query: %s", err)
+	}
+
+	return queries, nil
+}
+
+func (r *repository) DeleteSavedQuery(ctx context.Context, name string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM saved_queries WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("could not delete saved query %q: %s", name, err)
+	}
+	return nil
+}
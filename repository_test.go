@@ -1,16 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestCreateEntry(t *testing.T) {
-	repo, err := NewRepository(":memory:", "./schema-init.sql")
+func newTestRepository(t *testing.T) Repository {
+	repo, err := NewRepository(":memory:", migrationsFS)
 	if err != nil {
 		t.Fatalf("could not open repository: %s", err)
 	}
+	return repo
+}
+
+func TestCreateEntry(t *testing.T) {
+	repo := newTestRepository(t)
 
 	entry := Entry{
 		Date: time.Now(),
@@ -28,3 +41,793 @@ func TestCreateEntry(t *testing.T) {
 
 	t.Logf("created entry with id %q", id)
 }
+
+func TestDeleteEntry(t *testing.T) {
+	repo := newTestRepository(t)
+
+	ctx := context.Background()
+	now := time.Now()
+	id, err := repo.Create(ctx, &Entry{Date: now, Type: "test"})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	err = repo.Delete(ctx, id)
+	if err != nil {
+		t.Fatalf("could not delete entry: %s", err)
+	}
+
+	entry, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if entry == nil || entry.DeletedAt == nil {
+		t.Fatalf("entry %q should be in the trash, but got %#v", id, entry)
+	}
+
+	entries, err := repo.FindBetween(ctx, now.Add(-time.Hour), now.Add(time.Hour), Ascending)
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("deleted entry was listed: %#v", entries)
+	}
+
+	entries, err = repo.FindDeleted(ctx)
+	if err != nil {
+		t.Fatalf("could not find deleted entries: %s", err)
+	}
+	if len(entries) != 1 || entries[0].ID != id {
+		t.Fatalf("expected deleted entry in the trash, but got %#v", entries)
+	}
+
+	err = repo.Restore(ctx, id)
+	if err != nil {
+		t.Fatalf("could not restore entry: %s", err)
+	}
+
+	entries, err = repo.FindBetween(ctx, now.Add(-time.Hour), now.Add(time.Hour), Ascending)
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("restored entry was not listed: %#v", entries)
+	}
+
+	err = repo.Purge(ctx, id)
+	if err == nil {
+		t.Fatal("purging an entry that is not in the trash should fail")
+	}
+
+	err = repo.Delete(ctx, id)
+	if err != nil {
+		t.Fatalf("could not delete entry: %s", err)
+	}
+	err = repo.Purge(ctx, id)
+	if err != nil {
+		t.Fatalf("could not purge entry: %s", err)
+	}
+
+	entry, err = repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if entry != nil {
+		t.Fatalf("entry %q still exists after purge", id)
+	}
+}
+
+func TestUpdateKeepsHistory(t *testing.T) {
+	repo := newTestRepository(t)
+
+	ctx := context.Background()
+	entry := Entry{Date: time.Now(), Type: "test", Note: "first", Value: 1}
+	id, err := repo.Create(ctx, &entry)
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	entry.ID = id
+	entry.Version = 1
+	entry.Note = "second"
+	entry.Data = map[string]interface{}{"location": "home"}
+	err = repo.Update(ctx, &entry)
+	if err != nil {
+		t.Fatalf("could not update entry: %s", err)
+	}
+
+	revisions, err := repo.History(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get history: %s", err)
+	}
+	if len(revisions) != 1 || revisions[0].Entry.Note != "first" {
+		t.Fatalf("expected the first version in the history, but got %#v", revisions)
+	}
+
+	changes := Diff(revisions[0].Entry, entry)
+	if len(changes) != 2 || changes[0].Field != "note" || changes[1].Field != "data.location" {
+		t.Fatalf("unexpected changes %#v", changes)
+	}
+
+	err = repo.Revert(ctx, id, revisions[0].ID, 1)
+	if err != ErrConflict {
+		t.Errorf("expected a conflict for an outdated version, but got %v", err)
+	}
+	err = repo.Revert(ctx, id, revisions[0].ID+1, 2)
+	if err != ErrNoRevision {
+		t.Errorf("expected ErrNoRevision for an unknown revision, but got %v", err)
+	}
+
+	err = repo.Revert(ctx, id, revisions[0].ID, 2)
+	if err != nil {
+		t.Fatalf("could not revert entry: %s", err)
+	}
+
+	reverted, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if reverted.Note != "first" || reverted.Data != nil {
+		t.Fatalf("entry was not reverted: %#v", reverted)
+	}
+
+	revisions, err = repo.History(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get history: %s", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("expected the reverted version in the history, but got %#v", revisions)
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	repo := newTestRepository(t)
+
+	ctx := context.Background()
+	id, err := repo.Create(ctx, &Entry{Date: time.Now(), Type: "test"})
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	first, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	second := *first
+
+	first.Note = "from the first tab"
+	err = repo.Update(ctx, first)
+	if err != nil {
+		t.Fatalf("could not update entry: %s", err)
+	}
+	if first.Version != 2 {
+		t.Fatalf("expected version 2 after update, but got %d", first.Version)
+	}
+
+	second.Note = "from the second tab"
+	err = repo.Update(ctx, &second)
+	if err != ErrConflict {
+		t.Fatalf("expected conflict, but got %v", err)
+	}
+
+	err = repo.Delete(ctx, id)
+	if err != nil {
+		t.Fatalf("could not delete entry: %s", err)
+	}
+	deleted, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if deleted.Version != 3 {
+		t.Errorf("expected deleting to change the version to 3, but got %d", deleted.Version)
+	}
+
+	err = repo.Update(ctx, deleted)
+	if err != ErrNotFound {
+		t.Errorf("expected entries in the trash to be not found, but got %v", err)
+	}
+
+	err = repo.Restore(ctx, id)
+	if err != nil {
+		t.Fatalf("could not restore entry: %s", err)
+	}
+	restored, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if restored.Version != 4 {
+		t.Errorf("expected restoring to change the version to 4, but got %d", restored.Version)
+	}
+
+	// an etag from before the trash doesn't match the restored entry
+	err = repo.Update(ctx, first)
+	if err != ErrConflict {
+		t.Errorf("expected conflict after restoring, but got %v", err)
+	}
+}
+
+func TestUpdateDateAndType(t *testing.T) {
+	repo := newTestRepository(t)
+
+	ctx := context.Background()
+	entry := Entry{Date: time.Date(2019, 10, 1, 8, 30, 0, 0, time.UTC), Type: "kaffee"}
+	id, err := repo.Create(ctx, &entry)
+	if err != nil {
+		t.Fatalf("could not create entry: %s", err)
+	}
+
+	entry.ID = id
+	entry.Version = 1
+	entry.Date = time.Date(2019, 10, 2, 7, 45, 0, 0, time.FixedZone("CEST", 2*60*60))
+	entry.Type = "coffee"
+	err = repo.Update(ctx, &entry)
+	if err != nil {
+		t.Fatalf("could not update entry: %s", err)
+	}
+
+	updated, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if !updated.Date.Equal(entry.Date) || updated.Date.Location() != time.UTC {
+		t.Errorf("expected date %s in UTC, but got %s", entry.Date.UTC(), updated.Date)
+	}
+	if updated.Type != "coffee" {
+		t.Errorf("expected type coffee, but got %q", updated.Type)
+	}
+
+	revisions, err := repo.History(ctx, id)
+	if err != nil {
+		t.Fatalf("could not get history: %s", err)
+	}
+	changes := Diff(revisions[0].Entry, *updated)
+	if len(changes) != 2 || changes[0].Field != "date" || changes[1].Field != "type" {
+		t.Errorf("expected date and type to be changed, but got %#v", changes)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	repo := newTestRepository(t)
+
+	ctx := context.Background()
+	start := time.Date(2019, 10, 1, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		typ := "coffee"
+		if i%2 == 1 {
+			typ = "water"
+		}
+		_, err := repo.Create(ctx, &Entry{Date: start.Add(time.Duration(i) * time.Hour), Type: typ, Value: float64(i)})
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	q := ListQuery{Order: Descending, Limit: 2}
+	page, err := repo.List(ctx, q)
+	if err != nil {
+		t.Fatalf("could not list entries: %s", err)
+	}
+	if len(page.Entries) != 2 || page.Entries[0].Value != 4 || page.Next == nil || page.Prev != nil {
+		t.Fatalf("unexpected first page %#v", page)
+	}
+
+	q.Cursor = page.Next
+	page, err = repo.List(ctx, q)
+	if err != nil {
+		t.Fatalf("could not list entries: %s", err)
+	}
+	if len(page.Entries) != 2 || page.Entries[0].Value != 2 || page.Next == nil || page.Prev == nil {
+		t.Fatalf("unexpected second page %#v", page)
+	}
+
+	q.Cursor = page.Prev
+	page, err = repo.List(ctx, q)
+	if err != nil {
+		t.Fatalf("could not list entries: %s", err)
+	}
+	if len(page.Entries) != 2 || page.Entries[0].Value != 4 || page.Entries[1].Value != 3 || page.Prev != nil {
+		t.Fatalf("unexpected page going back %#v", page)
+	}
+
+	page, err = repo.List(ctx, ListQuery{Types: []string{"water"}, Order: Ascending, Limit: 10})
+	if err != nil {
+		t.Fatalf("could not list entries: %s", err)
+	}
+	if len(page.Entries) != 2 || page.Entries[0].Value != 1 || page.Next != nil {
+		t.Fatalf("unexpected filtered page %#v", page)
+	}
+}
+
+func TestFind(t *testing.T) {
+	repo := newTestRepository(t)
+
+	ctx := context.Background()
+	entries := []Entry{
+		{Date: time.Now(), Type: "coffee", Value: 1, Note: "with Oat milk", Data: map[string]interface{}{"location": "home"}},
+		{Date: time.Now(), Type: "coffee", Value: 2, Data: map[string]interface{}{"location": "work"}},
+		{Date: time.Now(), Type: "water", Value: 3},
+	}
+	for _, entry := range entries {
+		_, err := repo.Create(ctx, &entry)
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	minValue := 2.0
+	tests := []struct {
+		filter   Filter
+		expected int
+	}{
+		{Filter{}, 3},
+		{Filter{Types: []string{"coffee"}}, 2},
+		{Filter{MinValue: &minValue}, 2},
+		{Filter{NoteContains: "oat"}, 1},
+		{Filter{NoteContains: "%"}, 0},
+		{Filter{Data: []DataPredicate{{Key: "location", Op: "=", Value: "work"}}}, 1},
+		{Filter{Data: []DataPredicate{{Key: "location", Op: "exists"}}}, 2},
+		{Filter{Limit: 1}, 1},
+	}
+
+	for _, test := range tests {
+		found, err := repo.Find(ctx, test.filter)
+		if err != nil {
+			t.Fatalf("could not find entries with %#v: %s", test.filter, err)
+		}
+		if len(found) != test.expected {
+			t.Errorf("expected %d entries for %#v, but got %d", test.expected, test.filter, len(found))
+		}
+	}
+}
+
+func TestPlainDateRanges(t *testing.T) {
+	repo := newTestRepository(t)
+
+	ctx := context.Background()
+	for _, date := range []time.Time{
+		time.Date(2019, 10, 30, 23, 59, 59, 0, time.UTC),
+		time.Date(2019, 10, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, 10, 31, 23, 59, 59, 500, time.UTC),
+		time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		_, err := repo.Create(ctx, &Entry{Date: date, Type: "coffee"})
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	params := url.Values{"from": {"2019-10-31"}, "to": {"2019-10-31"}}
+	q, err := parseListQuery(params)
+	if err != nil {
+		t.Fatalf("could not parse list query: %s", err)
+	}
+	page, err := repo.List(ctx, q)
+	if err != nil {
+		t.Fatalf("could not list entries: %s", err)
+	}
+	if len(page.Entries) != 2 {
+		t.Errorf("expected the 2 entries of the day in the list, but got %d", len(page.Entries))
+	}
+
+	f, err := parseFilter(params)
+	if err != nil {
+		t.Fatalf("could not parse filter: %s", err)
+	}
+	found, err := repo.Find(ctx, f)
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("expected the 2 entries of the day in the filter, but got %d", len(found))
+	}
+
+	// timestamps are not extended to the end of the day
+	f.To, err = parseEndDateParam("2019-10-31T00:00:00Z")
+	if err != nil {
+		t.Fatalf("could not parse timestamp: %s", err)
+	}
+	found, err = repo.Find(ctx, f)
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(found) != 1 {
+		t.Errorf("expected 1 entry until midnight, but got %d", len(found))
+	}
+
+	f.To, err = parseUntil("2019-10-31", time.Now())
+	if err != nil {
+		t.Fatalf("could not parse -until: %s", err)
+	}
+	found, err = repo.Find(ctx, f)
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("expected the 2 entries of the day with -until, but got %d", len(found))
+	}
+}
+
+func TestQueryTableReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daily-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := NewRepository(filepath.Join(dir, "daily.db"), migrationsFS)
+	if err != nil {
+		t.Fatalf("could not open repository: %s", err)
+	}
+
+	ctx := context.Background()
+	for _, typ := range []string{"mood", "mood", "coffee"} {
+		_, err := repo.Create(ctx, &Entry{Date: time.Now(), Type: typ})
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	table, err := repo.QueryTable(ctx, "SELECT type, count(*) AS n FROM entries GROUP BY type ORDER BY type")
+	if err != nil {
+		t.Fatalf("could not query: %s", err)
+	}
+	if len(table.Columns) != 2 || table.Columns[0].Name != "type" || table.Columns[1].Name != "n" {
+		t.Errorf("unexpected columns %#v", table.Columns)
+	}
+	if table.RowCount != 2 || table.Rows[1][0] != "mood" || table.Rows[1][1] != int64(2) {
+		t.Errorf("unexpected rows %#v", table.Rows)
+	}
+
+	for _, query := range []string{"DELETE FROM entries", "DROP TABLE entries", "PRAGMA query_only = 0; DELETE FROM entries"} {
+		_, err = repo.QueryTable(ctx, query)
+		if err == nil {
+			t.Errorf("%q should have failed", query)
+		}
+	}
+
+	table, err = repo.QueryTable(ctx, "SELECT count(*) FROM entries")
+	if err != nil {
+		t.Fatalf("could not query: %s", err)
+	}
+	if table.Rows[0][0] != int64(3) {
+		t.Errorf("entries were changed by read-only queries, %v left", table.Rows[0][0])
+	}
+}
+
+func TestSearch(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	create := func(typ, note string, data map[string]interface{}) string {
+		id, err := repo.Create(ctx, &Entry{Date: time.Now(), Type: typ, Note: note, Data: data})
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+		return id
+	}
+	tired := create("mood", "tired but <ok>", nil)
+	coffee := create("coffee", "second cup", map[string]interface{}{"milk": "oat", "with": []interface{}{"sugar"}})
+	create("coffee", "ok, but tired", nil)
+
+	search := func(text string, types ...string) []string {
+		results, err := repo.Search(ctx, SearchQuery{Text: text, Types: types, Limit: 10})
+		if err != nil {
+			t.Fatalf("could not search for %q: %s", text, err)
+		}
+		ids := make([]string, len(results))
+		for i, result := range results {
+			ids[i] = result.Entry.ID
+		}
+		return ids
+	}
+
+	if ids := search(`"tired but ok"`); len(ids) != 1 || ids[0] != tired {
+		t.Errorf("phrase should only match %q, but got %v", tired, ids)
+	}
+	if ids := search("tir*", "mood"); len(ids) != 1 || ids[0] != tired {
+		t.Errorf("prefix with type filter should only match %q, but got %v", tired, ids)
+	}
+	if ids := search("oat sugar"); len(ids) != 1 || ids[0] != coffee {
+		t.Errorf("data values should match %q, but got %v", coffee, ids)
+	}
+	if ids := search("note:cup"); len(ids) != 1 || ids[0] != coffee {
+		t.Errorf("column filter should only match %q, but got %v", coffee, ids)
+	}
+	if ids := search("data:cup"); len(ids) != 0 {
+		t.Errorf("column filter should not match notes, but got %v", ids)
+	}
+
+	results, err := repo.Search(ctx, SearchQuery{Text: "ok", Types: []string{"mood"}, Limit: 10})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("could not search: %v, %s", results, err)
+	}
+	if results[0].Snippet != "tired but &lt;<mark>ok</mark>&gt;" {
+		t.Errorf("unexpected snippet %q", results[0].Snippet)
+	}
+
+	_, err = repo.Modify(ctx, coffee, func(entry *Entry) error {
+		entry.Data["milk"] = "soy"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not update entry: %s", err)
+	}
+	if ids := search("oat"); len(ids) != 0 {
+		t.Errorf("old data should not be found anymore, but got %v", ids)
+	}
+	if ids := search("soy"); len(ids) != 1 {
+		t.Errorf("new data should be found, but got %v", ids)
+	}
+
+	err = repo.Delete(ctx, tired)
+	if err != nil {
+		t.Fatalf("could not delete entry: %s", err)
+	}
+	if ids := search("tired"); len(ids) != 1 {
+		t.Errorf("deleted entry should not be found, but got %v", ids)
+	}
+	err = repo.Restore(ctx, tired)
+	if err != nil {
+		t.Fatalf("could not restore entry: %s", err)
+	}
+	if ids := search("tired"); len(ids) != 2 {
+		t.Errorf("restored entry should be found again, but got %v", ids)
+	}
+
+	invalid := []string{`"unbalanced`, "AND"}
+	if hasFTS5 {
+		invalid = append(invalid, "half-time", "nothing:here")
+	}
+	for _, text := range invalid {
+		_, err = repo.Search(ctx, SearchQuery{Text: text, Limit: 10})
+		if !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("%s: expected invalid search, but got %v", text, err)
+		}
+	}
+
+	// problems with the database are not the fault of the query
+	_, err = repo.(*repository).db.Exec("ALTER TABLE entry_tags RENAME TO entry_tags_old")
+	if err != nil {
+		t.Fatalf("could not rename table: %s", err)
+	}
+	_, err = repo.Search(ctx, SearchQuery{Text: "tired", Limit: 10})
+	if err == nil || errors.Is(err, ErrInvalidSearch) {
+		t.Errorf("expected a database error, but got %v", err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	date := time.Date(2019, 10, 1, 8, 30, 0, 0, time.UTC)
+	entries := []Entry{
+		{Date: date, Type: "coffee", Value: 2, Note: "oat, \"flat white\"", Data: map[string]interface{}{"milk": "oat"},
+			Tags: []string{"work", "cafe"}},
+		{Date: date.Add(time.Hour), Type: "mood", Value: 0.5, Data: map[string]interface{}{"with": []interface{}{"alice", "bob"}, "cups": 1.5}},
+	}
+	for i := range entries {
+		_, err := repo.Create(ctx, &entries[i])
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	var cw *CSVWriter
+	err := repo.Each(ctx, Filter{Order: Ascending}, func(keys []string) error {
+		var err error
+		cw, err = NewCSVWriter(buf, keys)
+		return err
+	}, func(entry *Entry) error {
+		entry.ID = "id"
+		return cw.Write(*entry)
+	})
+	if err != nil {
+		t.Fatalf("could not export: %s", err)
+	}
+	err = cw.Flush()
+	if err != nil {
+		t.Fatalf("could not export: %s", err)
+	}
+
+	expected := `id,date,type,note,value,tags,cups,milk,with
+id,2019-10-01T08:30:00Z,coffee,"oat, ""flat white""",2,cafe work,,oat,
+id,2019-10-01T09:30:00Z,mood,,0.5,,1.5,,alice; bob
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+}
+
+func TestEachPages(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	defer func(pageSize int) { eachPageSize = pageSize }(eachPageSize)
+	eachPageSize = 2
+
+	// entries at the same time are told apart by their id
+	date := time.Date(2019, 10, 1, 8, 30, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		_, err := repo.Create(ctx, &Entry{Date: date.Add(time.Duration(i/2) * time.Hour), Type: "test", Value: float64(i)})
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	for _, f := range []Filter{{Order: Ascending}, {Order: Descending}, {Order: Ascending, Limit: 5}, {Order: Descending, Limit: 4}} {
+		expected, err := repo.Find(ctx, f)
+		if err != nil {
+			t.Fatalf("could not find entries: %s", err)
+		}
+
+		var ids []string
+		err = repo.Each(ctx, f, nil, func(entry *Entry) error {
+			ids = append(ids, entry.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("could not export: %s", err)
+		}
+
+		if len(ids) != len(expected) {
+			t.Errorf("%+v: expected %d entries, but got %d", f, len(expected), len(ids))
+			continue
+		}
+		for i := range ids {
+			if ids[i] != expected[i].ID {
+				t.Errorf("%+v: expected %s at %d, but got %s", f, expected[i].ID, i, ids[i])
+			}
+		}
+	}
+}
+
+func TestRenameType(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	day := time.Date(2019, 10, 1, 8, 30, 0, 0, time.UTC)
+	ids := make([]string, 0, 4)
+	for i, typ := range []string{"kaffee", "kaffee", "coffee", "tea"} {
+		id, err := repo.Create(ctx, &Entry{Date: day.Add(time.Duration(i) * time.Hour), Type: typ})
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+		ids = append(ids, id)
+	}
+	err := repo.Delete(ctx, ids[1])
+	if err != nil {
+		t.Fatalf("could not delete entry: %s", err)
+	}
+
+	stats, err := repo.TypeStats(ctx)
+	if err != nil {
+		t.Fatalf("could not get type stats: %s", err)
+	}
+	expected := []TypeStats{
+		{Type: "coffee", Count: 1, LastUsed: day.Add(2 * time.Hour)},
+		{Type: "kaffee", Count: 1, LastUsed: day},
+		{Type: "tea", Count: 1, LastUsed: day.Add(3 * time.Hour)},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %v, but got %v", expected, stats)
+	}
+	for i := range stats {
+		if stats[i].Type != expected[i].Type || stats[i].Count != expected[i].Count || !stats[i].LastUsed.Equal(expected[i].LastUsed) {
+			t.Errorf("expected %v, but got %v", expected[i], stats[i])
+		}
+	}
+
+	_, err = repo.RenameType(ctx, "kaffee", "coffee", false)
+	if err != ErrTypeExists {
+		t.Errorf("renaming to a used type should fail, but got %v", err)
+	}
+
+	n, err := repo.RenameType(ctx, "kaffee", "coffee", true)
+	if err != nil {
+		t.Fatalf("could not merge types: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("expected both kaffee entries to be changed, but changed %d", n)
+	}
+
+	deleted, err := repo.Get(ctx, ids[1])
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if deleted.Type != "coffee" || deleted.Version != 3 {
+		t.Errorf("entries in the trash should be renamed too, but got %#v", deleted)
+	}
+
+	revisions, err := repo.History(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("could not get history: %s", err)
+	}
+	if len(revisions) != 1 || revisions[0].Entry.Type != "kaffee" {
+		t.Errorf("expected the old type in the history, but got %#v", revisions)
+	}
+
+	n, err = repo.RenameType(ctx, "tea", "chai", false)
+	if err != nil || n != 1 {
+		t.Errorf("could not rename tea (%d entries): %v", n, err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	date := time.Date(2019, 10, 1, 8, 30, 0, 0, time.UTC)
+	entries := []Entry{
+		{Date: date, Type: "coffee", Value: 1, Note: "at the #cafe", Tags: []string{"work"}},
+		{Date: date.Add(time.Hour), Type: "coffee", Value: 1, Tags: []string{"work"}},
+		{Date: date.Add(2 * time.Hour), Type: "water", Value: 1, Tags: []string{"#home"}},
+	}
+	for i := range entries {
+		var err error
+		entries[i].ID, err = repo.Create(ctx, &entries[i])
+		if err != nil {
+			t.Fatalf("could not create entry: %s", err)
+		}
+	}
+
+	entry, err := repo.Get(ctx, entries[0].ID)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if strings.Join(entry.Tags, " ") != "cafe work" {
+		t.Errorf("expected tags from the note to be added, but got %v", entry.Tags)
+	}
+
+	page, err := repo.List(ctx, ListQuery{Tags: []string{"work", "cafe"}, Order: Ascending, Limit: 10})
+	if err != nil {
+		t.Fatalf("could not list entries: %s", err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].ID != entries[0].ID {
+		t.Errorf("expected only the first entry to have both tags, but got %v", page.Entries)
+	}
+
+	found, err := repo.Find(ctx, Filter{Tags: []string{"home"}})
+	if err != nil {
+		t.Fatalf("could not find entries: %s", err)
+	}
+	if len(found) != 1 || found[0].Type != "water" {
+		t.Errorf("expected the water entry, but got %v", found)
+	}
+
+	entry.Note = "at home"
+	entry.Tags = []string{"home"}
+	err = repo.Update(ctx, entry)
+	if err != nil {
+		t.Fatalf("could not update entry: %s", err)
+	}
+
+	tags, err := repo.Tags(ctx)
+	if err != nil {
+		t.Fatalf("could not list tags: %s", err)
+	}
+	expected := []TagStats{{Tag: "home", Count: 2}, {Tag: "work", Count: 1}}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, but got %v", expected, tags)
+	}
+
+	revisions, err := repo.History(ctx, entry.ID)
+	if err != nil {
+		t.Fatalf("could not get history: %s", err)
+	}
+	if len(revisions) != 1 || strings.Join(revisions[0].Entry.Tags, " ") != "cafe work" {
+		t.Fatalf("expected the previous tags in the history, but got %v", revisions)
+	}
+	err = repo.Revert(ctx, entry.ID, revisions[0].ID, entry.Version)
+	if err != nil {
+		t.Fatalf("could not revert: %s", err)
+	}
+	entry, err = repo.Get(ctx, entry.ID)
+	if err != nil {
+		t.Fatalf("could not get entry: %s", err)
+	}
+	if strings.Join(entry.Tags, " ") != "cafe work" {
+		t.Errorf("expected the tags to be reverted, but got %v", entry.Tags)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SearchQuery is a full-text search for entries.  Text uses the SQLite
+// full-text query syntax, e.g. `"tired but ok"` for phrases, `tir*` for
+// prefixes and `note:coffee` to search only the note.
+type SearchQuery struct {
+	Text  string
+	Types []string
+	Limit int
+}
+
+// SearchResult is an entry matching a search, with a snippet of the
+// matching text where the matches are wrapped in <mark>.
+type SearchResult struct {
+	Entry   Entry         `json:"entry"`
+	Snippet template.HTML `json:"snippet"`
+}
+
+// ErrInvalidSearch is returned for search queries SQLite can't parse.
+var ErrInvalidSearch = errors.New("invalid search")
+
+// snippetStart and snippetEnd mark matches in snippets returned by
+// SQLite, before they are turned into html by highlightSnippet.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// highlightSnippet escapes the snippet and wraps the matches in <mark>.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.Replace(escaped, snippetStart, "<mark>", -1)
+	escaped = strings.Replace(escaped, snippetEnd, "</mark>", -1)
+	return template.HTML(escaped)
+}
+
+// dataText returns the string values in the additional data, which are
+// indexed for searching.  It is registered as the data_text function in
+// SQLite.
+func dataText(rawData interface{}) (string, error) {
+	var buf []byte
+	switch d := rawData.(type) {
+	case []byte:
+		buf = d
+	case string:
+		buf = []byte(d)
+	}
+	if len(buf) == 0 {
+		return "", nil
+	}
+
+	var data interface{}
+	err := json.Unmarshal(buf, &data)
+	if err != nil {
+		return "", fmt.Errorf("invalid additional data: %s", err)
+	}
+
+	texts := make([]string, 0, 4)
+	var collect func(val interface{})
+	collect = func(val interface{}) {
+		switch v := val.(type) {
+		case string:
+			texts = append(texts, v)
+		case []interface{}:
+			for _, elem := range v {
+				collect(elem)
+			}
+		case map[string]interface{}:
+			for _, elem := range v {
+				collect(elem)
+			}
+		}
+	}
+	collect(data)
+	return strings.Join(texts, " "), nil
+}
+
+// parseSearchQuery reads a SearchQuery from the `q`, `type` and `limit`
+// parameters.
+func parseSearchQuery(params url.Values) (SearchQuery, error) {
+	q := SearchQuery{
+		Text:  strings.TrimSpace(params.Get("q")),
+		Limit: defaultListLimit,
+	}
+
+	for _, types := range params["type"] {
+		for _, typ := range strings.Split(types, ",") {
+			if typ = strings.TrimSpace(typ); typ != "" {
+				q.Types = append(q.Types, typ)
+			}
+		}
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 1 || q.Limit > maxListLimit {
+			return q, fmt.Errorf("invalid limit %q, must be between 1 and %d", limit, maxListLimit)
+		}
+	}
+
+	return q, nil
+}
+
+func renderSearch(repo Repository, w http.ResponseWriter, req *http.Request) {
+	q, err := parseSearchQuery(req.URL.Query())
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadRequest
+	}
+	var results []SearchResult
+	if err == nil && q.Text != "" {
+		results, err = repo.Search(req.Context(), q)
+		switch {
+		case errors.Is(err, ErrInvalidSearch):
+			status = http.StatusBadRequest
+		case err != nil:
+			log.Printf("Could not search: %s", err)
+			status = http.StatusInternalServerError
+		}
+	}
+
+	if wantsJSON(req) || req.URL.Query().Get("format") == "json" {
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+		if err != nil {
+			log.Printf("Could not render results: %s", err)
+		}
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = tmplSearch.Execute(buf, map[string]interface{}{
+		"Title":   "Search",
+		"Query":   q,
+		"Types":   strings.Join(q.Types, ", "),
+		"Results": results,
+		"Error":   err,
+	})
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+		http.Error(w, "could not render search", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+var tmplSearch = template.Must(tmplBase.New("search").Parse(`{{ template "html-start" . }}
+	<form method="GET" action="/search">
+		<input type="search" name="q" value="{{ .Query.Text }}" placeholder="&quot;tired but ok&quot;, tir*, note:coffee" autofocus />
+		<input name="type" value="{{ .Types }}" placeholder="types, comma-separated" />
+		<input type="submit" value="Search" />
+	</form>
+
+	{{ if .Error }}
+	<div class="error">
+		<pre>{{ .Error }}</pre>
+	</div>
+	{{ end }}
+
+	<ul class="search-results">
+	{{ range .Results }}
+		<li>
+			<a href="/{{ .Entry.ID }}">{{ .Entry.Type }}</a>
+			<time datetime="{{ .Entry.Date.Format "2006-01-02T15:04:05Z07:00" }}">{{ .Entry.Date.Format "2006-01-02 15:04" }}</time>
+			<p>{{ .Snippet }}</p>
+		</li>
+	{{ else }}
+		{{ if .Query.Text }}<li>Nothing found.</li>{{ end }}
+	{{ end }}
+	</ul>
+{{ template "html-end" }}
+`))
@@ -0,0 +1,8 @@
+//go:build sqlite_fts5 || fts5
+// +build sqlite_fts5 fts5
+
+package main
+
+// hasFTS5 reports whether go-sqlite3 was built with FTS5, which new search
+// indexes use if it is available.
+const hasFTS5 = true
@@ -0,0 +1,8 @@
+//go:build !sqlite_fts5 && !fts5
+// +build !sqlite_fts5,!fts5
+
+package main
+
+// hasFTS5 reports whether go-sqlite3 was built with FTS5, otherwise new
+// search indexes use FTS4.  Build with `-tags sqlite_fts5` to enable it.
+const hasFTS5 = false
@@ -1,9 +1,8 @@
 let moodInput = document.querySelector("#entry-value");
-let moodGradient = document.querySelector("#mood-gradient");
-
-moodGradient.classList.remove("hidden");
+let moodGradient = document.createElement("div");
+moodGradient.id = "mood-gradient";
+document.body.insertBefore(moodGradient, document.body.firstChild);
 
 moodGradient.addEventListener("click", function(ev) {
 	moodInput.value = ev.clientX / document.body.clientWidth;
 });
-
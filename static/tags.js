@@ -0,0 +1,31 @@
+// suggest the known tags for the last word typed into the tags input,
+// keeping the ones before it
+let tagsInput = document.querySelector("#entry-tags");
+let tagsList = document.querySelector("#entry-tags-known");
+let knownTags = [];
+
+function suggestTags() {
+	let words = tagsInput.value.split(/[\s,]+/);
+	let current = words.pop();
+	let prefix = words.length > 0 ? words.join(" ") + " " : "";
+
+	tagsList.innerHTML = "";
+	for (let i = 0; i < knownTags.length; i++) {
+		let tag = knownTags[i].tag;
+		if (!tag.startsWith(current) || words.indexOf(tag) != -1) {
+			continue;
+		}
+		let option = document.createElement("option");
+		option.value = prefix + tag;
+		tagsList.appendChild(option);
+	}
+}
+
+fetch("/api/v1/tags")
+	.then(function(resp) { return resp.json(); })
+	.then(function(tags) {
+		knownTags = tags;
+		suggestTags();
+	});
+
+tagsInput.addEventListener("input", suggestTags);
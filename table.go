@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxTableRows limits how many rows of an arbitrary query are kept in
+// memory, the rest are only counted.
+const maxTableRows = 10000
+
+// Table is the result of an arbitrary SQL query.
+type Table struct {
+	Columns   []Column        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	RowCount  int             `json:"row_count"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// Column describes a column of a Table.  Type is the declared type of the
+// column, or the type of its values for computed columns.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func scanTable(rows *sql.Rows) (*Table, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("could not get columns: %s", err)
+	}
+
+	table := &Table{
+		Columns: make([]Column, len(columnTypes)),
+		Rows:    make([][]interface{}, 0, 100),
+	}
+	for i, columnType := range columnTypes {
+		table.Columns[i] = Column{Name: columnType.Name(), Type: columnType.DatabaseTypeName()}
+	}
+
+	for rows.Next() {
+		row := make([]interface{}, len(table.Columns))
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan row: %s", err)
+		}
+
+		table.RowCount++
+		if len(table.Rows) >= maxTableRows {
+			table.Truncated = true
+			continue
+		}
+
+		for i, val := range row {
+			// text comes back as bytes, which would be encoded as base64
+			if buf, ok := val.([]byte); ok {
+				row[i] = string(buf)
+			}
+			if table.Columns[i].Type == "" && row[i] != nil {
+				table.Columns[i].Type = valueType(row[i])
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not finish query: %s", err)
+	}
+
+	return table, nil
+}
+
+func valueType(val interface{}) string {
+	switch val.(type) {
+	case int64:
+		return "INTEGER"
+	case float64:
+		return "REAL"
+	case string:
+		return "TEXT"
+	case time.Time:
+		return "TIMESTAMP"
+	case bool:
+		return "BOOLEAN"
+	default:
+		return "BLOB"
+	}
+}
+
+// formatValue formats a single value for text output, NULL is empty.
+func formatValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeTable writes the table as JSON or CSV, depending on format.
+func writeTable(w http.ResponseWriter, format string, table *Table) error {
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		return table.RenderCSV(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return table.RenderJSON(w)
+}
+
+func (t *Table) RenderJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+func (t *Table) RenderCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	header := make([]string, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = column.Name
+	}
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, val := range row {
+			record[i] = formatValue(val)
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+var tmplTable = template.Must(template.New("table").Funcs(template.FuncMap{
+	"formatValue": formatValue,
+	"isNull": func(val interface{}) bool {
+		return val == nil
+	},
+}).Parse(`{{ define "table" }}
+<table class="result">
+	<thead>
+		<tr>
+		{{ range .Columns }}
+			<th>{{ .Name }} <small>{{ .Type }}</small></th>
+		{{ end }}
+		</tr>
+	</thead>
+	<tbody>
+	{{ range .Rows }}
+		<tr>
+		{{ range . }}
+			<td>{{ if isNull . }}<i>NULL</i>{{ else }}{{ formatValue . }}{{ end }}</td>
+		{{ end }}
+		</tr>
+	{{ end }}
+	</tbody>
+</table>
+<p>{{ .RowCount }} rows{{ if .Truncated }}, only the first {{ len .Rows }} are shown{{ end }}</p>
+{{ end }}
+`))
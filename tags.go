@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// tagPattern matches valid tags, which are used in urls and notes
+	// and so can't contain spaces, commas or slashes.
+	tagPattern = regexp.MustCompile(`^[\pL\pN_-]+$`)
+
+	// noteTagPattern matches #tags in notes.
+	noteTagPattern = regexp.MustCompile(`(?:^|[\s(])#([\pL\pN_-]+)`)
+)
+
+// tagSeparator separates the tags of an entry in the database, where they
+// are read with group_concat.
+const tagSeparator = "\x1f"
+
+// tagsColumn selects the tags of the entry in the current row of entries.
+const tagsColumn = "(SELECT group_concat(tag, char(31)) FROM entry_tags WHERE entry_id = entries.id)"
+
+// NoteTags returns the #tags mentioned in the note.
+func NoteTags(note string) []string {
+	tags := []string{}
+	for _, match := range noteTagPattern.FindAllStringSubmatch(note, -1) {
+		tags = append(tags, match[1])
+	}
+	return tags
+}
+
+// entryTags returns the tags of the entry combined with the ones in its
+// note, which is what gets stored.
+func entryTags(entry *Entry) []string {
+	tags := make([]string, 0, len(entry.Tags))
+	tags = append(tags, entry.Tags...)
+	return normalizeTags(append(tags, NoteTags(entry.Note)...))
+}
+
+// normalizeTags strips a leading # and surrounding space from the tags,
+// removes empty and duplicate ones and sorts the rest.  It returns nil if
+// there are no tags left.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// parseTags splits tags separated by commas or spaces, as they are typed
+// into forms and parameters.
+func parseTags(vals ...string) []string {
+	tags := make([]string, 0, len(vals))
+	for _, val := range vals {
+		tags = append(tags, strings.FieldsFunc(val, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n'
+		})...)
+	}
+	return normalizeTags(tags)
+}
+
+func splitTags(joined string) []string {
+	if joined == "" {
+		return nil
+	}
+	tags := strings.Split(joined, tagSeparator)
+	sort.Strings(tags)
+	return tags
+}
+
+// dataTags returns the tags that used to be kept in the "tags" key of the
+// additional data, separated by tagSeparator.  It is registered as the
+// data_tags function in SQLite, to move them to entry_tags.
+func dataTags(rawData interface{}) (string, error) {
+	data, err := parseSQLData(rawData)
+	if err != nil {
+		return "", err
+	}
+
+	var tags []string
+	switch val := data["tags"].(type) {
+	case string:
+		tags = parseTags(val)
+	case []interface{}:
+		strs := make([]string, 0, len(val))
+		for _, item := range val {
+			switch item := item.(type) {
+			case string:
+				strs = append(strs, item)
+			case float64:
+				strs = append(strs, formatNumber(item))
+			}
+		}
+		tags = parseTags(strs...)
+	}
+
+	valid := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tagPattern.MatchString(tag) {
+			valid = append(valid, tag)
+		}
+	}
+	return strings.Join(valid, tagSeparator), nil
+}
+
+// dataWithout returns the additional data without key.  It is registered
+// as the data_without function in SQLite.
+func dataWithout(rawData interface{}, key string) (string, error) {
+	data, err := parseSQLData(rawData)
+	if err != nil {
+		return "", err
+	}
+
+	delete(data, key)
+	if len(data) == 0 {
+		return "null", nil
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("could not serialize additional data: %s", err)
+	}
+	return string(buf), nil
+}
+
+func parseSQLData(rawData interface{}) (map[string]interface{}, error) {
+	var buf []byte
+	switch d := rawData.(type) {
+	case []byte:
+		buf = d
+	case string:
+		buf = []byte(d)
+	}
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	var data map[string]interface{}
+	err := json.Unmarshal(buf, &data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid additional data: %s", err)
+	}
+	return data, nil
+}
+
+// renderTags lists all tags with the number of entries that have them.
+func renderTags(repo Repository, w http.ResponseWriter, req *http.Request) {
+	tags, err := repo.Tags(req.Context())
+	if err != nil {
+		log.Printf("Could not list tags: %s", err)
+		http.Error(w, fmt.Sprintf("Could not list tags: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if wantsJSON(req) {
+		writeAPIJSON(w, http.StatusOK, tags)
+		return
+	}
+
+	err = tmplTags.Execute(w, map[string]interface{}{
+		"Title": "Tags - daily",
+		"Tags":  tags,
+	})
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+	}
+}
+
+// renderTagEntries lists the entries with the tag, like / with the tag
+// filter set.
+func renderTagEntries(repo Repository, w http.ResponseWriter, req *http.Request, tag string) {
+	params := req.URL.Query()
+	params.Set("tag", tag)
+	req.URL.RawQuery = params.Encode()
+
+	renderEntries(repo, w, req)
+}
+
+var tmplTags = template.Must(tmplEntryBase.New("tags").Parse(`{{ template "html-start" . }}
+<a href="/">/</a>
+<a href="/new">/new</a>
+
+<section id="content">
+	<h1>Tags</h1>
+
+	<ul class="tags">
+	{{ range .Tags }}
+		<li><a href="/tags/{{ .Tag }}">#{{ .Tag }}</a> ({{ .Count }})</li>
+	{{ else }}
+		<li>No entries are tagged yet, use <code>#tag</code> in notes or the tags field.</li>
+	{{ end }}
+	</ul>
+</section>
+{{ template "html-end" }}
+`))
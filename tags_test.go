@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNoteTags(t *testing.T) {
+	var testCases = []struct {
+		note     string
+		expected []string
+	}{
+		{"", []string{}},
+		{"#work", []string{"work"}},
+		{"oat milk at the #cafe, with (#alice) #über_mama", []string{"cafe", "alice", "über_mama"}},
+		{"issue#42 and # and mail@#example", []string{}},
+	}
+
+	for _, tc := range testCases {
+		tags := NoteTags(tc.note)
+		if !reflect.DeepEqual(tags, tc.expected) {
+			t.Errorf("%q: expected %v, but got %v", tc.note, tc.expected, tags)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags := parseTags("work, #cafe  home", "work,,later")
+	expected := []string{"cafe", "home", "later", "work"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, but got %v", expected, tags)
+	}
+
+	if tags := parseTags("", " , "); tags != nil {
+		t.Errorf("expected no tags, but got %v", tags)
+	}
+}
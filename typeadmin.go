@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// typeInfo is a type as listed on /types, either used by entries, defined
+// or both.
+type typeInfo struct {
+	Name       string          `json:"name"`
+	Count      int             `json:"count"`
+	LastUsed   *time.Time      `json:"last_used,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Definition *TypeDefinition `json:"definition,omitempty"`
+}
+
+// typeInfos lists the types of all entries and all defined types.
+func typeInfos(repo Repository, req *http.Request) ([]typeInfo, error) {
+	stats, err := repo.TypeStats(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make(map[string]*typeInfo, len(stats))
+	for _, ts := range stats {
+		lastUsed := ts.LastUsed
+		infos[ts.Type] = &typeInfo{Name: ts.Type, Count: ts.Count, LastUsed: &lastUsed}
+	}
+	for _, td := range entryTypes.All() {
+		info, ok := infos[td.Name]
+		if !ok {
+			info = &typeInfo{Name: td.Name}
+			infos[td.Name] = info
+		}
+		info.Definition = td
+		info.Source = entryTypes.Source(td.Name)
+	}
+
+	result := make([]typeInfo, 0, len(infos))
+	for _, info := range infos {
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// renderTypes lists all types with how often and when they were used last.
+func renderTypes(repo Repository, w http.ResponseWriter, req *http.Request) {
+	infos, err := typeInfos(repo, req)
+	if err != nil {
+		log.Printf("Could not list types: %s", err)
+		http.Error(w, fmt.Sprintf("Could not list types: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if wantsJSON(req) {
+		writeAPIJSON(w, http.StatusOK, infos)
+		return
+	}
+
+	err = tmplTypes.Execute(w, map[string]interface{}{
+		"Title": "Types - daily",
+		"Types": infos,
+	})
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+	}
+}
+
+// createType creates an empty definition for the type in the `name`
+// parameter, to be filled in on its page.
+func createType(repo Repository, w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimSpace(req.FormValue("name"))
+	if name == "" {
+		http.Error(w, "name must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := entryTypes.Lookup(name); !ok {
+		err := entryTypes.Save(&TypeDefinition{Name: name})
+		if err != nil {
+			log.Printf("Could not create type %q: %s", name, err)
+			http.Error(w, fmt.Sprintf("Could not create type: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	http.Redirect(w, req, typePath(name), http.StatusFound)
+}
+
+func typePath(name string) string {
+	return "/types/" + url.PathEscape(name)
+}
+
+// renderType shows the definition of a type for editing, together with
+// the forms to rename it and to merge it into another type.
+func renderType(repo Repository, w http.ResponseWriter, req *http.Request, name string, td *TypeDefinition, status int, formErr error) {
+	infos, err := typeInfos(repo, req)
+	if err != nil {
+		log.Printf("Could not list types: %s", err)
+		http.Error(w, fmt.Sprintf("Could not list types: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	info := typeInfo{Name: name}
+	others := make([]string, 0, len(infos))
+	for _, other := range infos {
+		if other.Name == name {
+			info = other
+			continue
+		}
+		others = append(others, other.Name)
+	}
+	if info.Count == 0 && info.Definition == nil && td == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	if td == nil {
+		td = entryTypes.Get(name)
+	}
+
+	w.WriteHeader(status)
+	err = tmplType.Execute(w, map[string]interface{}{
+		"Title":      name + " - types - daily",
+		"Info":       info,
+		"Def":        td,
+		"FieldRows":  append(append([]FieldDefinition{}, td.Fields...), FieldDefinition{}),
+		"FieldTypes": fieldTypes,
+		"Others":     others,
+		"Error":      formErr,
+	})
+	if err != nil {
+		log.Printf("Could not execute template: %s", err)
+	}
+}
+
+// saveType saves the definition of a type from the form on its page.
+func saveType(repo Repository, w http.ResponseWriter, req *http.Request, name string) {
+	err := req.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	td, err := parseTypeForm(req.PostForm, entryTypes.Get(name))
+	if err == nil {
+		err = entryTypes.Save(td)
+	}
+	if err != nil {
+		renderType(repo, w, req, name, td, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	http.Redirect(w, req, typePath(name), http.StatusFound)
+}
+
+// parseTypeForm reads a type definition from the form on its page.  The
+// stylesheet and script can't be changed there and are kept from prev.
+func parseTypeForm(form url.Values, prev *TypeDefinition) (*TypeDefinition, error) {
+	td := &TypeDefinition{
+		Name:        prev.Name,
+		Label:       strings.TrimSpace(form.Get("label")),
+		Unit:        strings.TrimSpace(form.Get("unit")),
+		Widget:      form.Get("widget"),
+		Color:       strings.TrimSpace(form.Get("color")),
+		ColorMax:    strings.TrimSpace(form.Get("color_max")),
+		Aggregation: form.Get("aggregation"),
+		Stylesheet:  prev.Stylesheet,
+		Script:      prev.Script,
+	}
+
+	// read the fields first, so that they are shown again if anything
+	// else is invalid
+	for i := 0; ; i++ {
+		prefix := "field." + strconv.Itoa(i) + "."
+		if _, ok := form[prefix+"name"]; !ok {
+			break
+		}
+
+		field := FieldDefinition{
+			Name:     strings.TrimSpace(form.Get(prefix + "name")),
+			Label:    strings.TrimSpace(form.Get(prefix + "label")),
+			Type:     form.Get(prefix + "type"),
+			Required: form.Get(prefix+"required") != "",
+			Pattern:  form.Get(prefix + "pattern"),
+		}
+		for _, choice := range strings.Split(form.Get(prefix+"enum"), ",") {
+			if choice = strings.TrimSpace(choice); choice != "" {
+				field.Enum = append(field.Enum, choice)
+			}
+		}
+		if field.Name == "" || form.Get(prefix+"remove") != "" {
+			continue
+		}
+		td.Fields = append(td.Fields, field)
+	}
+
+	var err error
+	numbers := []struct {
+		key string
+		val **float64
+	}{
+		{"min", &td.Min},
+		{"max", &td.Max},
+		{"step", &td.Step},
+		{"default", &td.Default},
+	}
+	for _, number := range numbers {
+		*number.val, err = parseOptionalFloat(form, number.key)
+		if err != nil {
+			return td, err
+		}
+	}
+
+	scale, err := parseOptionalFloat(form, "scale")
+	if err != nil {
+		return td, err
+	}
+	if scale != nil {
+		td.Scale = *scale
+	}
+
+	if s := strings.TrimSpace(form.Get("max_note_length")); s != "" {
+		td.MaxNoteLength, err = strconv.Atoi(s)
+		if err != nil {
+			return td, fmt.Errorf("max_note_length %q is not a number", s)
+		}
+	}
+
+	return td, nil
+}
+
+func parseOptionalFloat(form url.Values, key string) (*float64, error) {
+	s := strings.TrimSpace(form.Get(key))
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q is not a number", key, s)
+	}
+	return &f, nil
+}
+
+// renameType renames a type across all entries and moves its definition
+// along, or merges it into an existing type if `merge` is set.
+func renameType(repo Repository, w http.ResponseWriter, req *http.Request, name string) {
+	to := strings.TrimSpace(req.FormValue("to"))
+	merge := req.FormValue("merge") != ""
+	if to == "" || to == name {
+		http.Error(w, "the new name must not be empty or the same as the old one", http.StatusBadRequest)
+		return
+	}
+	// the definition is moved to a file named after the type, so check
+	// that before any entries are changed
+	if !validTypeFileName(to) {
+		http.Error(w, fmt.Sprintf("%q can't be used as a type, it must not start with . or contain slashes", to), http.StatusBadRequest)
+		return
+	}
+
+	_, defined := entryTypes.Lookup(to)
+	if !merge && defined {
+		http.Error(w, fmt.Sprintf("%q is defined already, merge into it instead", to), http.StatusConflict)
+		return
+	}
+
+	// merged entries have to be valid for the type they are merged into
+	if merge {
+		invalid, err := invalidAsType(req.Context(), repo, name, to)
+		if err != nil {
+			log.Printf("Could not check entries of %q: %s", name, err)
+			http.Error(w, fmt.Sprintf("Could not check entries: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if len(invalid) > 0 {
+			http.Error(w, fmt.Sprintf("%d entries would not be valid as %q, change them first:\n\n%s",
+				len(invalid), to, strings.Join(invalid, "\n")), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// a renamed type keeps its definition, which is written first so that
+	// the entries are not renamed if that fails
+	td, hasDefinition := entryTypes.Lookup(name)
+	if hasDefinition && !merge {
+		renamed := *td
+		renamed.Name = to
+		err := entryTypes.Save(&renamed)
+		if err != nil {
+			log.Printf("Could not save definition of %q: %s", to, err)
+			http.Error(w, fmt.Sprintf("Could not save definition: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	n, err := repo.RenameType(req.Context(), name, to, merge)
+	if err != nil && hasDefinition && !merge {
+		if removeErr := entryTypes.Remove(to); removeErr != nil {
+			log.Printf("Could not remove definition of %q again: %s", to, removeErr)
+		}
+	}
+	if err == ErrTypeExists {
+		http.Error(w, fmt.Sprintf("%q is used already, merge into it instead", to), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Could not rename type %q to %q: %s", name, to, err)
+		http.Error(w, fmt.Sprintf("Could not rename type: %s", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Changed type of %d entries from %q to %q", n, name, to)
+
+	// the old definition is not needed anymore, neither for renamed nor
+	// for merged types
+	err = entryTypes.Remove(name)
+	if err != nil {
+		log.Printf("Could not remove definition of %q: %s", name, err)
+		http.Error(w, fmt.Sprintf("Renamed the entries, but could not remove the old definition: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, req, typePath(to), http.StatusFound)
+}
+
+// invalidAsType describes the entries of type from, including the ones in
+// the trash, that would not be valid if they had type to.
+func invalidAsType(ctx context.Context, repo Repository, from, to string) ([]string, error) {
+	entries, err := repo.Find(ctx, Filter{Types: []string{from}, IncludeDeleted: true, Order: Ascending})
+	if err != nil {
+		return nil, err
+	}
+
+	td := entryTypes.Get(to)
+	invalid := []string{}
+	for _, entry := range entries {
+		entry.Type = to
+		if err := td.Validate(&entry); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s (%s): %s", entry.ID, entry.Date.Format("2006-01-02 15:04"), err))
+		}
+	}
+	return invalid, nil
+}
+
+var tmplTypes = template.Must(tmplEntryBase.New("types").Parse(`{{ template "html-start" . }}
+<a href="/">/</a>
+<a href="/new">/new</a>
+
+<section id="content">
+	<h1>Types</h1>
+
+	<table class="types">
+		<thead>
+			<tr><th>Type</th><th></th><th>Entries</th><th>Last used</th><th>Defined in</th></tr>
+		</thead>
+		<tbody>
+		{{ range .Types }}
+			<tr>
+				<td><a href="/types/{{ .Name }}">{{ .Name }}</a>{{ with .Definition }}{{ with .Label }} ({{ . }}){{ end }}{{ end }}</td>
+				<td>{{ (visualize .Name 1 1).ToHTML 16 16 }}</td>
+				<td><a href="/query?type={{ .Name }}">{{ .Count }}</a></td>
+				<td>{{ with .LastUsed }}{{ .Format "2006-01-02 15:04" }}{{ end }}</td>
+				<td>{{ .Source }}</td>
+			</tr>
+		{{ end }}
+		</tbody>
+	</table>
+
+	<form method="POST" action="/types">
+		<input name="name" placeholder="name" required />
+		<input type="submit" value="New type" />
+	</form>
+</section>
+{{ template "html-end" }}
+`))
+
+var tmplType = template.Must(tmplEntryBase.New("type").Parse(`{{ template "html-start" . }}
+<a href="/types">/types</a>
+<a href="/new/{{ .Info.Name }}">/new/{{ .Info.Name }}</a>
+
+<section id="content">
+	<h1>{{ .Info.Name }} {{ (visualize .Info.Name 1 1).ToHTML 16 16 }}</h1>
+
+	<p>
+		<a href="/query?type={{ .Info.Name }}">{{ .Info.Count }} entries</a>{{ with .Info.LastUsed }}, the last one at {{ .Format "2006-01-02 15:04" }}{{ end }}.
+		{{ with .Info.Source }}Defined in {{ . }}.{{ else }}Not defined yet.{{ end }}
+	</p>
+
+	{{ with .Error }}<p class="error">{{ . }}</p>{{ end }}
+
+	<form method="POST" action="/types/{{ .Info.Name }}" class="type-definition">
+		{{ with .Def }}
+		<div class="field"><label>Label <input name="label" value="{{ .Label }}" /></label></div>
+		<div class="field"><label>Unit <input name="unit" value="{{ .Unit }}" /></label></div>
+		<div class="field">
+			<label>Input
+				<select name="widget">
+					<option value="number" {{ if eq .InputType "number" }}selected{{ end }}>number</option>
+					<option value="range" {{ if eq .InputType "range" }}selected{{ end }}>range</option>
+				</select>
+			</label>
+			<label>Min <input name="min" type="number" step="any" value="{{ with .Min }}{{ . }}{{ end }}" /></label>
+			<label>Max <input name="max" type="number" step="any" value="{{ with .Max }}{{ . }}{{ end }}" /></label>
+			<label>Step <input name="step" type="number" step="any" value="{{ with .Step }}{{ . }}{{ end }}" /></label>
+			<label>Default <input name="default" type="number" step="any" value="{{ with .Default }}{{ . }}{{ end }}" /></label>
+		</div>
+		<div class="field">
+			<label>Color <input name="color" value="{{ .Color }}" placeholder="grey" /></label>
+			<label>Shade to <input name="color_max" value="{{ .ColorMax }}" placeholder="#28cb00" /></label>
+		</div>
+		<div class="field">
+			<label>Aggregation
+				<select name="aggregation">
+					<option value="sum" {{ if eq .Aggregation "" "sum" }}selected{{ end }}>sum</option>
+					<option value="avg" {{ if eq .Aggregation "avg" }}selected{{ end }}>avg</option>
+					<option value="count" {{ if eq .Aggregation "count" }}selected{{ end }}>count</option>
+				</select>
+			</label>
+			<label>Scale <input name="scale" type="number" step="any" value="{{ with .Scale }}{{ . }}{{ end }}" placeholder="1" /></label>
+		</div>
+		<div class="field"><label>Max. note length <input name="max_note_length" type="number" min="0" value="{{ with .MaxNoteLength }}{{ . }}{{ end }}" /></label></div>
+		{{ end }}
+
+		<h2>Fields</h2>
+
+		<table class="fields">
+			<thead>
+				<tr><th>Name</th><th>Label</th><th>Type</th><th>Required</th><th>Choices</th><th>Pattern</th><th>Remove</th></tr>
+			</thead>
+			<tbody>
+			{{ range $i, $field := .FieldRows }}
+				<tr>
+					<td><input name="field.{{ $i }}.name" value="{{ .Name }}" placeholder="new field" /></td>
+					<td><input name="field.{{ $i }}.label" value="{{ .Label }}" /></td>
+					<td>
+						<select name="field.{{ $i }}.type">
+							<option value="">any</option>
+							{{ range $.FieldTypes }}<option {{ if eq . $field.Type }}selected{{ end }}>{{ . }}</option>{{ end }}
+						</select>
+					</td>
+					<td><input name="field.{{ $i }}.required" type="checkbox" value="true" {{ if .Required }}checked{{ end }} /></td>
+					<td><input name="field.{{ $i }}.enum" value="{{ range $j, $choice := .Enum }}{{ if $j }}, {{ end }}{{ $choice }}{{ end }}" placeholder="bad, ok, good" /></td>
+					<td><input name="field.{{ $i }}.pattern" value="{{ .Pattern }}" /></td>
+					<td>{{ if .Name }}<input name="field.{{ $i }}.remove" type="checkbox" value="true" />{{ end }}</td>
+				</tr>
+			{{ end }}
+			</tbody>
+		</table>
+
+		<input type="submit" value="Save" />
+	</form>
+
+	<h2>Rename</h2>
+
+	<form method="POST" action="/types/{{ .Info.Name }}/rename">
+		<input name="to" placeholder="new name" required />
+		<input type="submit" value="Rename" />
+	</form>
+
+	{{ if .Others }}
+	<h2>Merge</h2>
+
+	<form method="POST" action="/types/{{ .Info.Name }}/rename">
+		<input type="hidden" name="merge" value="true" />
+		<label>Change all entries to
+			<select name="to">
+				{{ range .Others }}<option>{{ . }}</option>{{ end }}
+			</select>
+		</label>
+		<input type="submit" value="Merge" />
+	</form>
+	{{ end }}
+</section>
+{{ template "html-end" }}
+`))
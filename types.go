@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed types/*.yaml
+var embeddedTypes embed.FS
+
+// entryTypes contains the type definitions compiled into the binary,
+// overridden by the ones in -types-dir.
+var entryTypes = newTypeRegistry(mustSub(embeddedTypes, "types"))
+
+// TypeDefinition describes how entries of a type are entered and shown.
+type TypeDefinition struct {
+	Name  string `json:"name" yaml:"name"`
+	Label string `json:"label,omitempty" yaml:"label,omitempty"`
+	Unit  string `json:"unit,omitempty" yaml:"unit,omitempty"`
+
+	// Widget is the input for the value, "number" (the default) or
+	// "range".
+	Widget  string   `json:"widget,omitempty" yaml:"widget,omitempty"`
+	Min     *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	Step    *float64 `json:"step,omitempty" yaml:"step,omitempty"`
+	Default *float64 `json:"default,omitempty" yaml:"default,omitempty"`
+
+	// Color is used to visualize the entries.  If ColorMax is set as
+	// well, both must be hex colors and the aggregated value picks a
+	// shade between them, from Min (0 by default) to Max (1 by default).
+	Color    string `json:"color,omitempty" yaml:"color,omitempty"`
+	ColorMax string `json:"color_max,omitempty" yaml:"color_max,omitempty"`
+
+	// Aggregation combines the values of several entries, "sum" (the
+	// default), "avg" or "count".  The result is divided by Scale to
+	// get the number of blocks to show.
+	Aggregation string  `json:"aggregation,omitempty" yaml:"aggregation,omitempty"`
+	Scale       float64 `json:"scale,omitempty" yaml:"scale,omitempty"`
+
+	// MaxNoteLength limits the number of characters in notes if it is
+	// not 0.
+	MaxNoteLength int `json:"max_note_length,omitempty" yaml:"max_note_length,omitempty"`
+
+	Stylesheet string `json:"stylesheet,omitempty" yaml:"stylesheet,omitempty"`
+	Script     string `json:"script,omitempty" yaml:"script,omitempty"`
+
+	Fields []FieldDefinition `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// FieldDefinition declares a key in the additional data of an entry.
+type FieldDefinition struct {
+	Name  string `json:"name" yaml:"name"`
+	Label string `json:"label,omitempty" yaml:"label,omitempty"`
+
+	// Type is one of fieldTypes and determines the input in the forms
+	// and how values are parsed.  Values of any type are allowed if it
+	// is empty.
+	Type     string `json:"type,omitempty" yaml:"type,omitempty"`
+	Required bool   `json:"required,omitempty" yaml:"required,omitempty"`
+
+	// Enum and Pattern restrict string values, including the ones in
+	// lists.  Enum lists the choices of enum fields.  The pattern has
+	// to match the whole value.
+	Enum    []string `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Pattern string   `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// ValueLabel returns the label of the value input, including the unit.
+func (td *TypeDefinition) ValueLabel() string {
+	label := "Value"
+	if td.Label != "" {
+		label = td.Label
+	}
+	if td.Unit != "" {
+		label += " (" + td.Unit + ")"
+	}
+	return label
+}
+
+// InputType returns the type attribute of the value input.
+func (td *TypeDefinition) InputType() string {
+	if td.Widget == "" {
+		return "number"
+	}
+	return td.Widget
+}
+
+// NewEntry returns an entry of the type with the default value.
+func (td *TypeDefinition) NewEntry() *Entry {
+	entry := &Entry{Type: td.Name}
+	if td.Default != nil {
+		entry.Value = *td.Default
+	}
+	return entry
+}
+
+// Field returns the declared field with the given name, or nil.
+func (td *TypeDefinition) Field(name string) *FieldDefinition {
+	for i := range td.Fields {
+		if td.Fields[i].Name == name {
+			return &td.Fields[i]
+		}
+	}
+	return nil
+}
+
+// ParseField converts the values of the field with the name like
+// FieldDefinition.Parse.  Undeclared fields are stored as text, the same
+// way in forms, on the command line, in quick add and in imports.
+func (td *TypeDefinition) ParseField(name string, vals []string) (val interface{}, ok bool) {
+	field := td.Field(name)
+	if field == nil {
+		field = &FieldDefinition{Name: name}
+	}
+	return field.Parse(vals)
+}
+
+// DisplayLabel returns the label of the field, or its name.
+func (fd FieldDefinition) DisplayLabel() string {
+	if fd.Label != "" {
+		return fd.Label
+	}
+	return fd.Name
+}
+
+func (td *TypeDefinition) validate() error {
+	if td.Name == "" {
+		return fmt.Errorf("name is missing")
+	}
+	switch td.Widget {
+	case "", "number", "range":
+	default:
+		return fmt.Errorf("unknown widget %q, must be number or range", td.Widget)
+	}
+	if td.Widget == "range" && (td.Min == nil || td.Max == nil) {
+		return fmt.Errorf("range needs min and max")
+	}
+	if td.Min != nil && td.Max != nil && *td.Min >= *td.Max {
+		return fmt.Errorf("min must be less than max")
+	}
+	if td.Step != nil && *td.Step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	switch td.Aggregation {
+	case "", "sum", "avg", "count":
+	default:
+		return fmt.Errorf("unknown aggregation %q, must be sum, avg or count", td.Aggregation)
+	}
+	if td.Scale < 0 {
+		return fmt.Errorf("scale must be positive")
+	}
+	if td.ColorMax != "" {
+		if _, err := parseHexColor(td.Color); err != nil {
+			return fmt.Errorf("color: %s", err)
+		}
+		if _, err := parseHexColor(td.ColorMax); err != nil {
+			return fmt.Errorf("color_max: %s", err)
+		}
+	}
+
+	if td.MaxNoteLength < 0 {
+		return fmt.Errorf("max_note_length must be positive")
+	}
+
+	seen := map[string]bool{}
+	for i := range td.Fields {
+		field := &td.Fields[i]
+		if field.Name == "" {
+			return fmt.Errorf("field %d has no name", i+1)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("field %q is declared twice", field.Name)
+		}
+		seen[field.Name] = true
+
+		err := field.validate()
+		if err != nil {
+			return fmt.Errorf("field %q: %s", field.Name, err)
+		}
+	}
+	return nil
+}
+
+func (fd *FieldDefinition) validate() error {
+	if fd.Type != "" && !containsString(fieldTypes, fd.Type) {
+		return fmt.Errorf("unknown type %q, must be one of %s", fd.Type, strings.Join(fieldTypes, ", "))
+	}
+	switch fd.Type {
+	case "", "string", "enum", "list":
+	default:
+		if len(fd.Enum) > 0 || fd.Pattern != "" {
+			return fmt.Errorf("enum and pattern only apply to text")
+		}
+	}
+	if fd.Type == "enum" && len(fd.Enum) == 0 {
+		return fmt.Errorf("enum needs a list of choices in enum")
+	}
+	if fd.Pattern != "" {
+		pattern, err := regexp.Compile("^(?:" + fd.Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+		fd.pattern = pattern
+	}
+	return nil
+}
+
+// Visualize aggregates the values of numEntries entries of a type, which
+// sum up to sumValue.
+func (td *TypeDefinition) Visualize(numEntries int, sumValue float64) VisualizeInfo {
+	var value float64
+	switch td.Aggregation {
+	case "avg":
+		value = sumValue / float64(numEntries)
+	case "count":
+		value = float64(numEntries)
+	default:
+		value = sumValue
+	}
+
+	if td.ColorMax != "" {
+		min, max := 0.0, 1.0
+		if td.Min != nil {
+			min = *td.Min
+		}
+		if td.Max != nil {
+			max = *td.Max
+		}
+		t := math.Max(0, math.Min(1, (value-min)/(max-min)))
+
+		// both have been checked by validate
+		from, _ := parseHexColor(td.Color)
+		to, _ := parseHexColor(td.ColorMax)
+		var shade [3]float64
+		for i := range shade {
+			shade[i] = from[i] + (to[i]-from[i])*t
+		}
+		return VisualizeInfo{Color: fmt.Sprintf("rgb(%.1f, %.1f, %.1f)", shade[0], shade[1], shade[2]), Amount: 1}
+	}
+
+	color := td.Color
+	if color == "" {
+		color = "grey"
+	}
+	if td.Scale > 0 {
+		value /= td.Scale
+	}
+	return VisualizeInfo{Color: color, Amount: value}
+}
+
+// parseHexColor parses colors like #28cb00 into their red, green and blue
+// components.
+func parseHexColor(color string) ([3]float64, error) {
+	var rgb [3]float64
+	if len(color) != 7 || color[0] != '#' {
+		return rgb, fmt.Errorf("%q is not a color like #28cb00", color)
+	}
+	for i := range rgb {
+		n, err := strconv.ParseUint(color[1+2*i:3+2*i], 16, 8)
+		if err != nil {
+			return rgb, fmt.Errorf("%q is not a color like #28cb00", color)
+		}
+		rgb[i] = float64(n)
+	}
+	return rgb, nil
+}
+
+// typeRegistry holds the current type definitions.  The definitions in
+// the directory passed to Load override the builtin ones with the same
+// name.  They are checked for changes by Watch and replace the previous
+// ones as a whole, or not at all if any of them is invalid.
+type typeRegistry struct {
+	builtin map[string]*TypeDefinition
+
+	mu    sync.RWMutex
+	dir   string
+	stamp string
+	types map[string]*TypeDefinition
+	// files maps the types defined in dir to their file
+	files map[string]string
+}
+
+func newTypeRegistry(builtin fs.FS) *typeRegistry {
+	types, _, err := loadTypeDefinitions(builtin)
+	if err != nil {
+		panic(fmt.Sprintf("invalid builtin type definitions: %s", err))
+	}
+	return &typeRegistry{builtin: types, types: types}
+}
+
+// All returns all definitions, sorted by name.
+func (r *typeRegistry) All() []*TypeDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make([]*TypeDefinition, 0, len(r.types))
+	for _, td := range r.types {
+		all = append(all, td)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+// Source returns the file the named type is defined in, "builtin" or ""
+// if it is not defined.
+func (r *typeRegistry) Source(name string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if file, ok := r.files[name]; ok {
+		return filepath.Join(r.dir, file)
+	}
+	if _, ok := r.builtin[name]; ok {
+		return "builtin"
+	}
+	return ""
+}
+
+// Lookup returns the definition of the named type, if there is one.  The
+// definition must not be modified.
+func (r *typeRegistry) Lookup(name string) (*TypeDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	td, ok := r.types[name]
+	return td, ok
+}
+
+// Get returns the definition of the named type, or an empty one if it is
+// not defined.
+func (r *typeRegistry) Get(name string) *TypeDefinition {
+	if td, ok := r.Lookup(name); ok {
+		return td
+	}
+	return &TypeDefinition{Name: name}
+}
+
+// Load loads the definitions in dir, which doesn't have to exist (yet).
+func (r *typeRegistry) Load(dir string) error {
+	r.mu.Lock()
+	r.dir = dir
+	r.stamp = ""
+	r.mu.Unlock()
+
+	_, err := r.reload()
+	return err
+}
+
+// Watch reloads the definitions every interval if the files in the
+// directory have changed.
+func (r *typeRegistry) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		changed, err := r.reload()
+		if err != nil {
+			log.Printf("Could not reload type definitions: %s", err)
+			continue
+		}
+		if changed {
+			log.Printf("Reloaded type definitions")
+		}
+	}
+}
+
+func (r *typeRegistry) reload() (changed bool, err error) {
+	r.mu.RLock()
+	dir, prevStamp := r.dir, r.stamp
+	r.mu.RUnlock()
+
+	stamp, err := typeFilesStamp(dir)
+	if err != nil {
+		return false, err
+	}
+	if stamp == prevStamp {
+		return false, nil
+	}
+
+	loaded, files := map[string]*TypeDefinition{}, map[string]string{}
+	if stamp != "" {
+		loaded, files, err = loadTypeDefinitions(os.DirFS(dir))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// remember invalid files as well, so that they are only reported once
+	r.stamp = stamp
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", dir, err)
+	}
+
+	types := make(map[string]*TypeDefinition, len(r.builtin)+len(loaded))
+	for name, td := range r.builtin {
+		types[name] = td
+	}
+	for name, td := range loaded {
+		types[name] = td
+	}
+	r.types = types
+	r.files = files
+	return true, nil
+}
+
+// Save writes the definition to a YAML file in the directory, replacing
+// the file it was defined in before, and loads it.
+func (r *typeRegistry) Save(td *TypeDefinition) error {
+	if !validTypeFileName(td.Name) {
+		return fmt.Errorf("%q can't be used as a file name", td.Name)
+	}
+	err := td.validate()
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(td)
+	if err != nil {
+		return fmt.Errorf("could not serialize definition: %s", err)
+	}
+
+	r.mu.RLock()
+	dir, prevFile := r.dir, r.files[td.Name]
+	r.mu.RUnlock()
+	if dir == "" {
+		return fmt.Errorf("no directory for type definitions, see -types-dir")
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory for type definitions: %s", err)
+	}
+
+	// write to a file that is not loaded first, so that a half-written
+	// definition is never loaded
+	file := td.Name + ".yaml"
+	tmpFile := filepath.Join(dir, "."+file+".tmp")
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write definition: %s", err)
+	}
+	err = os.Rename(tmpFile, filepath.Join(dir, file))
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("could not write definition: %s", err)
+	}
+	if prevFile != "" && prevFile != file {
+		err = os.Remove(filepath.Join(dir, prevFile))
+		if err != nil {
+			return fmt.Errorf("could not remove previous definition: %s", err)
+		}
+	}
+
+	_, err = r.reload()
+	return err
+}
+
+// Remove removes the file the named type is defined in.  Builtin
+// definitions stay.
+func (r *typeRegistry) Remove(name string) error {
+	r.mu.RLock()
+	dir, file := r.dir, r.files[name]
+	r.mu.RUnlock()
+	if file == "" {
+		return nil
+	}
+
+	err := os.Remove(filepath.Join(dir, file))
+	if err != nil {
+		return fmt.Errorf("could not remove definition: %s", err)
+	}
+
+	_, err = r.reload()
+	return err
+}
+
+func validTypeFileName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func isTypeFile(name string) bool {
+	switch path.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return !strings.HasPrefix(name, ".")
+	default:
+		return false
+	}
+}
+
+// typeFilesStamp returns a string that changes when any of the type files
+// in dir changes.  It is empty if there are none.
+func typeFilesStamp(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("could not list type definitions: %s", err)
+	}
+
+	var stamp strings.Builder
+	for _, info := range infos {
+		if info.IsDir() || !isTypeFile(info.Name()) {
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s %d %d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp.String(), nil
+}
+
+// loadTypeDefinitions reads the YAML and JSON files in the root of fsys,
+// each containing one definition.  The name of a type defaults to the
+// name of its file.
+func loadTypeDefinitions(fsys fs.FS) (types map[string]*TypeDefinition, definedIn map[string]string, err error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list type definitions: %s", err)
+	}
+
+	types = map[string]*TypeDefinition{}
+	definedIn = map[string]string{}
+	for _, file := range files {
+		if file.IsDir() || !isTypeFile(file.Name()) {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, file.Name())
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read %s: %s", file.Name(), err)
+		}
+
+		td, err := parseTypeDefinition(file.Name(), data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", file.Name(), err)
+		}
+
+		if other, ok := definedIn[td.Name]; ok {
+			return nil, nil, fmt.Errorf("type %q is defined in both %s and %s", td.Name, other, file.Name())
+		}
+		definedIn[td.Name] = file.Name()
+		types[td.Name] = td
+	}
+	return types, definedIn, nil
+}
+
+func parseTypeDefinition(fileName string, data []byte) (*TypeDefinition, error) {
+	var td TypeDefinition
+	ext := path.Ext(fileName)
+	if ext == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&td)
+		if err != nil {
+			return nil, fmt.Errorf("invalid json: %s", err)
+		}
+	} else {
+		err := yaml.UnmarshalStrict(data, &td)
+		if err != nil {
+			return nil, fmt.Errorf("invalid yaml: %s", err)
+		}
+	}
+
+	if td.Name == "" {
+		td.Name = strings.TrimSuffix(fileName, ext)
+	}
+	err := td.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &td, nil
+}
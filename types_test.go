@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVisualizeBuiltinTypes(t *testing.T) {
+	tests := []struct {
+		typ      string
+		num      int
+		sum      float64
+		expected VisualizeInfo
+	}{
+		{"coffee", 2, 3, VisualizeInfo{Color: "brown", Amount: 3}},
+		{"mood", 2, 1.2, VisualizeInfo{Color: "yellow", Amount: 0.6}},
+		{"throat", 1, 0.5, VisualizeInfo{Color: "rgb(147.5, 229.0, 127.5)", Amount: 1}},
+		{"expense", 3, 25, VisualizeInfo{Color: "red", Amount: 2.5}},
+		{"undefined", 3, 25, VisualizeInfo{Color: "grey", Amount: 3}},
+	}
+
+	for _, test := range tests {
+		info := Visualize(test.typ, test.num, test.sum)
+		if info != test.expected {
+			t.Errorf("expected %#v for %s, but got %#v", test.expected, test.typ, info)
+		}
+	}
+}
+
+func TestTypeRegistryReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daily-types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newTypeRegistry(mustSub(embeddedTypes, "types"))
+	err = r.Load(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("missing directory should be fine: %s", err)
+	}
+
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("sleep.json", `{"label": "Sleep", "unit": "hours", "fields": [{"name": "quality"}]}`)
+	write("coffee.yaml", "label: Kaffee\ncolor: black\n")
+	err = r.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if td := r.Get("sleep"); td.ValueLabel() != "Sleep (hours)" || td.Field("quality") == nil {
+		t.Errorf("sleep was not loaded: %#v", td)
+	}
+	if td := r.Get("coffee"); td.Color != "black" {
+		t.Errorf("coffee should be overridden, but is %#v", td)
+	}
+	if _, ok := r.Lookup("mood"); !ok {
+		t.Error("builtin types should still be defined")
+	}
+
+	write("broken.yaml", "widget: slider\n")
+	changed, err := r.reload()
+	if err == nil || changed {
+		t.Errorf("invalid definitions should not be loaded (changed %v, err %v)", changed, err)
+	}
+	if td := r.Get("coffee"); td.Color != "black" {
+		t.Errorf("previous definitions should be kept, but coffee is %#v", td)
+	}
+
+	os.Remove(filepath.Join(dir, "broken.yaml"))
+	os.Remove(filepath.Join(dir, "coffee.yaml"))
+	changed, err = r.reload()
+	if err != nil || !changed {
+		t.Fatalf("could not reload (changed %v): %v", changed, err)
+	}
+	if td := r.Get("coffee"); td.Color != "brown" {
+		t.Errorf("coffee should be the builtin one again, but is %#v", td)
+	}
+}
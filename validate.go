@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError maps the fields of an invalid entry to what is wrong
+// with them.  Keys in the additional data are prefixed with "data.".
+type ValidationError map[string]string
+
+func (ve ValidationError) Error() string {
+	fields := make([]string, 0, len(ve))
+	for field := range ve {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, len(fields))
+	for i, field := range fields {
+		msgs[i] = field + " " + ve[field]
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Data returns the problem with the given key in the additional data.
+func (ve ValidationError) Data(key string) string {
+	return ve["data."+key]
+}
+
+// ValidateEntry checks an entry against the definition of its type,
+// returning a ValidationError if it is invalid.
+func ValidateEntry(entry *Entry) error {
+	return entryTypes.Get(entry.Type).Validate(entry)
+}
+
+// Validate checks an entry against the definition, returning a
+// ValidationError if it is invalid.
+func (td *TypeDefinition) Validate(entry *Entry) error {
+	errs := ValidationError{}
+
+	if entry.Type == "" {
+		errs["type"] = "must not be empty"
+	}
+
+	switch {
+	case !isFinite(entry.Value):
+		errs["value"] = "must be a finite number"
+	case td.Min != nil && entry.Value < *td.Min:
+		errs["value"] = "must be at least " + formatNumber(*td.Min)
+	case td.Max != nil && entry.Value > *td.Max:
+		errs["value"] = "must be at most " + formatNumber(*td.Max)
+	}
+
+	for _, tag := range entry.Tags {
+		if !tagPattern.MatchString(strings.TrimPrefix(tag, "#")) {
+			errs["tags"] = fmt.Sprintf("%q is not a valid tag, use letters, digits, - and _", tag)
+			break
+		}
+	}
+
+	if td.MaxNoteLength > 0 && utf8.RuneCountInString(entry.Note) > td.MaxNoteLength {
+		errs["note"] = fmt.Sprintf("must not be longer than %d characters", td.MaxNoteLength)
+	}
+
+	for _, field := range td.Fields {
+		val, ok := entry.Data[field.Name]
+		if !ok || val == nil || val == "" {
+			if field.Required {
+				errs["data."+field.Name] = "is required"
+			}
+			continue
+		}
+
+		err := field.check(val)
+		if err != nil {
+			errs["data."+field.Name] = err.Error()
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (fd *FieldDefinition) check(val interface{}) error {
+	switch fd.Type {
+	case "string", "enum":
+		if _, ok := val.(string); !ok {
+			return fmt.Errorf("must be text")
+		}
+	case "number":
+		if f, ok := val.(float64); !ok || !isFinite(f) {
+			return fmt.Errorf("must be a number")
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			return fmt.Errorf("must be true or false")
+		}
+	case "date":
+		s, ok := val.(string)
+		if _, err := time.Parse(fieldDateLayout, s); !ok || err != nil {
+			return fmt.Errorf("must be a date like 2019-10-01")
+		}
+	case "duration":
+		if f, ok := val.(float64); !ok || !isFinite(f) {
+			return fmt.Errorf("must be a duration like 1h30m")
+		}
+	case "list":
+		list, ok := val.([]interface{})
+		if !ok {
+			return fmt.Errorf("must be a list")
+		}
+		for _, item := range list {
+			if _, ok := item.(string); !ok {
+				return fmt.Errorf("must be a list of text")
+			}
+		}
+	}
+
+	vals, ok := val.([]interface{})
+	if !ok {
+		vals = []interface{}{val}
+	}
+	for _, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			continue
+		}
+		if len(fd.Enum) > 0 && !containsString(fd.Enum, s) {
+			return fmt.Errorf("must be one of %s", strings.Join(fd.Enum, ", "))
+		}
+		if fd.pattern != nil && !fd.pattern.MatchString(s) {
+			return fmt.Errorf("%q does not match %s", s, fd.Pattern)
+		}
+	}
+	return nil
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
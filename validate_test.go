@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	td, err := parseTypeDefinition("sleep.yaml", []byte(`
+min: 0
+max: 24
+max_note_length: 10
+fields:
+  - name: quality
+    type: string
+    required: true
+    enum: [bad, ok, good]
+  - name: rooms
+    type: list
+    pattern: "[a-z]+"
+  - name: naps
+    type: number
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		entry    Entry
+		expected ValidationError
+	}{
+		{Entry{Type: "sleep", Value: 8, Data: map[string]interface{}{"quality": "ok"}}, nil},
+		{Entry{Type: "sleep", Value: 8, Data: map[string]interface{}{
+			"quality": "ok",
+			"rooms":   []interface{}{"bedroom", "couch"},
+			"naps":    1.0,
+			"other":   true,
+		}}, nil},
+		{Entry{Type: "sleep", Value: 25, Note: "much too long"}, ValidationError{
+			"value":        "must be at most 24",
+			"note":         "must not be longer than 10 characters",
+			"data.quality": "is required",
+		}},
+		{Entry{Value: -1, Data: map[string]interface{}{
+			"quality": "great",
+			"rooms":   "bedroom",
+			"naps":    "1",
+		}}, ValidationError{
+			"type":         "must not be empty",
+			"value":        "must be at least 0",
+			"data.quality": "must be one of bad, ok, good",
+			"data.rooms":   "must be a list",
+			"data.naps":    "must be a number",
+		}},
+		{Entry{Type: "sleep", Data: map[string]interface{}{
+			"quality": "ok",
+			"rooms":   []interface{}{"bedroom", "Kitchen"},
+		}}, ValidationError{
+			"data.rooms": `"Kitchen" does not match [a-z]+`,
+		}},
+		{Entry{Type: "sleep", Value: math.NaN(), Data: map[string]interface{}{"quality": "ok"}}, ValidationError{
+			"value": "must be a finite number",
+		}},
+		{Entry{Type: "sleep", Value: math.Inf(-1), Data: map[string]interface{}{"quality": "ok"}}, ValidationError{
+			"value": "must be a finite number",
+		}},
+		{Entry{Type: "sleep", Data: map[string]interface{}{"quality": "ok", "naps": math.Inf(1)}}, ValidationError{
+			"data.naps": "must be a number",
+		}},
+	}
+
+	for _, test := range tests {
+		err := td.Validate(&test.entry)
+		if test.expected == nil {
+			if err != nil {
+				t.Errorf("expected %#v to be valid, but got %s", test.entry, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("expected %#v for %#v, but got %#v", test.expected, test.entry, err)
+		}
+	}
+
+	_, err = parseTypeDefinition("sleep.yaml", []byte("fields: [{name: quality, type: number, enum: [a]}]"))
+	if err == nil {
+		t.Error("enum should only be allowed for strings")
+	}
+}